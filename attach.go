@@ -0,0 +1,123 @@
+package pop3
+
+import (
+    "bufio"
+    "io"
+    "mime"
+    "mime/multipart"
+    "net/mail"
+    "net/textproto"
+    "strings"
+)
+
+// HasAttachments guesses from the header of the given message whether it has
+// attachments, without downloading the body. This is a heuristic: a
+// multipart/mixed message is taken to have attachments, as is a single part
+// message that is an attachment itself or is not text. It is wrong e.g. for
+// multipart/mixed messages whose parts are all inline text. Exchange's
+// X-MS-Has-Attach header is used when present. Use HasAttachmentsStrict for
+// a certain answer.
+func (c *Client) HasAttachments(msg int) (bool, error) {
+    text, err := c.TOP(msg, 0)
+    if err != nil {
+        return false, err
+    }
+    m, err := mail.ReadMessage(strings.NewReader(text + "\n\n"))
+    if err != nil {
+        return false, err
+    }
+    return headerHasAttachments(m.Header), nil
+}
+
+
+// headerHasAttachments is the heuristic of HasAttachments.
+func headerHasAttachments(header mail.Header) bool {
+    switch strings.ToLower(strings.TrimSpace(header.Get("X-MS-Has-Attach"))) {
+    case "yes":
+        return true
+    case "no":
+        return false
+    }
+
+    mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+    if err != nil {
+        // A missing or broken Content-Type means text/plain.
+        mediaType = "text/plain"
+    }
+    if mediaType == "multipart/mixed" {
+        return true
+    }
+    return isAttachment(textproto.MIMEHeader(header), false)
+}
+
+
+// HasAttachmentsStrict reports whether the given message has attachments by
+// walking its MIME structure. The message is streamed until the first
+// attachment is found; the rest of it is then discarded unparsed. Messages
+// without attachments are read in full.
+//
+// A part is an attachment if its Content-Disposition says so, or if it has
+// a file name and is not part of a multipart/related body (where named parts
+// are usually images embedded in the HTML text).
+func (c *Client) HasAttachmentsStrict(msg int) (found bool, err error) {
+    r, err := c.RetrReader(msg)
+    if err != nil {
+        return
+    }
+    defer func() {
+        if e := r.Close(); err == nil {
+            err = e
+        }
+    }()
+
+    tp := textproto.NewReader(bufio.NewReader(r))
+    header, err := tp.ReadMIMEHeader()
+    if err != nil && err != io.EOF {
+        return
+    }
+    return walkAttachments(header, tp.R, false)
+}
+
+
+// walkAttachments reports whether the part with the given header and body,
+// or any part nested in it, is an attachment.
+func walkAttachments(header textproto.MIMEHeader, body io.Reader, related bool) (bool, error) {
+    mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+    if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+        return isAttachment(header, related), nil
+    }
+
+    mr := multipart.NewReader(body, params["boundary"])
+    for {
+        p, err := mr.NextRawPart()
+        if err == io.EOF {
+            return false, nil
+        }
+        if err != nil {
+            return false, err
+        }
+        found, err := walkAttachments(p.Header, p, related || mediaType == "multipart/related")
+        if found || err != nil {
+            return found, err
+        }
+    }
+}
+
+
+// isAttachment reports whether a single, non-multipart part is an
+// attachment.
+func isAttachment(header textproto.MIMEHeader, related bool) bool {
+    disposition, dparams, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+    if err == nil && disposition == "attachment" {
+        return true
+    }
+
+    mediaType, cparams, err := mime.ParseMediaType(header.Get("Content-Type"))
+    if err != nil {
+        mediaType = "text/plain"
+    }
+    if (dparams["filename"] != "" || cparams["name"] != "") && !related {
+        return true
+    }
+    return !related && !strings.HasPrefix(mediaType, "text/") && !strings.HasPrefix(mediaType, "multipart/")
+}
@@ -0,0 +1,215 @@
+package pop3
+
+import (
+    "crypto/md5"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// LoginDelayError is returned by AuthWithBackoff when the server demands a
+// longer wait before the next login than the caller allowed.
+type LoginDelayError struct {
+    Delay time.Duration // how long to wait before trying again
+    Err   *Error        // the server response
+}
+
+func (e *LoginDelayError) Error() string {
+    return fmt.Sprintf("login delayed by server for %s: %s", e.Delay, e.Err.Text)
+}
+
+
+// APOP authenticates with the APOP command, which sends an MD5 digest of the
+// secret combined with the timestamp from the server greeting instead of the
+// secret itself. It returns ErrUnsupported without sending anything if the
+// greeting holds no timestamp.
+//
+// Servers rarely say why APOP failed. If the response has no response code,
+// the text is examined: rejected credentials get the code "AUTH", while
+// APOP being unavailable (disabled, not set up for the user, unknown
+// command) gets "SYS/PERM", so that callers can decide whether to fall back
+// to USER/PASS. On success the credentials are remembered for Reconnect.
+func (c *Client) APOP(user, secret string) error {
+    timestamp := apopTimestamp.FindString(c.greeting)
+    if timestamp == "" {
+        return ErrUnsupported
+    }
+
+    sum := md5.Sum([]byte(timestamp + secret))
+    _, err := c.Cmd("APOP %s %s\r\n", user, hex.EncodeToString(sum[:]))
+    if e, ok := err.(*Error); ok && e.Code == "" {
+        e.Code = classifyAPOPError(e.Text)
+        return e
+    }
+    if err != nil {
+        return err
+    }
+
+    c.reauth = func(c *Client) error {
+        return c.APOP(user, secret)
+    }
+    return nil
+}
+
+
+// XOAUTH2 logs in with an OAuth 2.0 access token, using the SASL mechanism
+// XOAUTH2 of Gmail and Outlook.com with the AUTH command (RFC 5034). On
+// failure the server sends details of the error as a challenge, which is
+// answered with an empty line as the mechanism requires; the -ERR response
+// which follows is returned. Tokens expire, so XOAUTH2 does not set the
+// function used by Reconnect: use a CredentialProvider (see LoginWith)
+// to log in again with a fresh token.
+func (c *Client) XOAUTH2(user, token string) error {
+    if err := c.requireState(StateAuthorization); err != nil {
+        return err
+    }
+    ir := base64.StdEncoding.EncodeToString([]byte("user=" + user + "\x01auth=Bearer " + token + "\x01\x01"))
+    cmd := c.command("AUTH XOAUTH2 %s\r\n", ir)
+
+    start, read := c.now(), c.stats.BytesRead
+    err := c.write(cmd)
+    if err == nil {
+        var b []byte
+        if b, err = c.bin.Peek(2); err == nil && string(b) == "+ " {
+            if _, err = c.readLine(); err == nil {
+                err = c.write(c.command("\r\n"))
+            }
+        }
+    }
+    status := ""
+    if err == nil {
+        status, err = c.response(cmd)
+    }
+    c.logCmd(cmd, status, err, start, c.stats.BytesRead-read)
+    return err
+}
+
+
+// The timestamp in a greeting announcing APOP, see RFC 1939 section 7.
+var apopTimestamp = regexp.MustCompile(`<[^<>@]*@[^<>]*>`)
+
+
+// Phrases in APOP failures, in the order they are checked. They are
+// matched as whole words.
+var apopErrorPhrases = []struct {
+    code    string
+    phrases []string
+}{
+    {"IN-USE", []string{"in use", "locked", "lock"}},
+    {"SYS/PERM", []string{"not supported", "not enabled", "not allowed", "not available", "disabled", "unknown command", "bad command", "invalid command", "unrecognized", "no apop", "no secret", "not permitted"}},
+    {"AUTH", []string{"password", "authentication", "auth", "credential", "credentials", "invalid", "incorrect", "mismatch", "bad", "denied", "failed", "wrong"}},
+}
+
+
+// A response code in brackets inside the text of a response, which some
+// servers put after the text instead of in front.
+var inlineCode = regexp.MustCompile(`\[((?:IN-USE|SYS/PERM|SYS/TEMP|AUTH|LOGIN-DELAY)\b[^\]]*)\]`)
+
+
+// classifyAPOPError guesses the response code of an APOP failure from its
+// text, returning "" if nothing matches. An explicit code in brackets wins
+// over the phrases.
+func classifyAPOPError(text string) string {
+    if m := inlineCode.FindStringSubmatch(strings.ToUpper(text)); m != nil {
+        return m[1]
+    }
+    words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+    text = " " + strings.Join(words, " ") + " "
+    for _, group := range apopErrorPhrases {
+        for _, p := range group.phrases {
+            if strings.Contains(text, " "+p+" ") {
+                return group.code
+            }
+        }
+    }
+    return ""
+}
+
+
+// AuthWithBackoff works like Auth, but if the server rejects the login with
+// the LOGIN-DELAY response code, it waits for the required delay and tries
+// once more. The delay is taken from the response code ("[LOGIN-DELAY n]")
+// or the LOGIN-DELAY capability, if CAPA has been issued before; if neither
+// tells, maxWait is used. If the delay is longer than maxWait, a
+// *LoginDelayError carrying the delay is returned without waiting.
+func (c *Client) AuthWithBackoff(user, pass string, maxWait time.Duration) error {
+    err := c.Auth(user, pass)
+    e, ok := err.(*Error)
+    if !ok || !isLoginDelay(e.Code) {
+        return err
+    }
+
+    delay, known := c.loginDelay(e)
+    if !known {
+        delay = maxWait
+    }
+    if delay > maxWait {
+        return &LoginDelayError{Delay: delay, Err: e}
+    }
+
+    if err = c.sleep(delay); err != nil {
+        return err
+    }
+    return c.Auth(user, pass)
+}
+
+
+func isLoginDelay(code string) bool {
+    return strings.EqualFold(verb(code), "LOGIN-DELAY")
+}
+
+
+// loginDelay returns the delay required by a LOGIN-DELAY response.
+func (c *Client) loginDelay(e *Error) (time.Duration, bool) {
+    fs := strings.Fields(e.Code)
+    if len(fs) > 1 {
+        if n, err := strconv.Atoi(fs[1]); err == nil && n >= 0 {
+            return time.Duration(n) * time.Second, true
+        }
+    }
+    if c.caps != nil && c.caps.LoginDelay >= 0 {
+        return time.Duration(c.caps.LoginDelay) * time.Second, true
+    }
+    return 0, false
+}
+
+
+// ErrAuthFailed is returned by CheckCredentials when the server rejects the
+// credentials. The error also wraps the *Error holding the server response.
+var ErrAuthFailed = errors.New("authentication failed")
+
+
+// CheckCredentials tells whether a login is valid: it connects with dial,
+// logs in with auth (e.g. a closure calling Auth or APOP), confirms with
+// STAT that the maildrop is open and ends the session with QUIT, without
+// fetching or deleting anything. It returns nil for valid credentials and an
+// error wrapping ErrAuthFailed if the server rejects them. Other errors,
+// including temporary refusals such as IN-USE or LOGIN-DELAY, which say
+// nothing about the credentials, are returned as they are.
+func CheckCredentials(dial func() (*Client, error), auth func(*Client) error) error {
+    c, err := dial()
+    if err != nil {
+        return err
+    }
+    defer c.conn.Close()
+
+    if err = auth(c); err != nil {
+        var e *Error
+        if errors.As(err, &e) && !retryable(e) {
+            return fmt.Errorf("%w: %w", ErrAuthFailed, err)
+        }
+        return err
+    }
+    if _, _, err = c.STAT(); err != nil {
+        return err
+    }
+    return c.QUIT()
+}
@@ -0,0 +1,63 @@
+package pop3
+
+import (
+    "errors"
+    "io"
+    "net"
+)
+
+// BatchResult is the outcome of a batch operation for one message. Err is
+// nil if the operation succeeded for the message.
+type BatchResult struct {
+    MsgNum int
+    Err    error
+}
+
+
+// runBatch calls fn for each message and reports the outcome per message.
+// Errors which only concern one message (such as -ERR responses or messages
+// that cannot be parsed) are recorded and the batch goes on; a transport
+// error stops the batch, since the connection is unusable afterwards, and is
+// returned as err. Messages not attempted have no result.
+func (c *Client) runBatch(msgs []int, fn func(msg int) error) (results []BatchResult, err error) {
+    results = make([]BatchResult, 0, len(msgs))
+    for _, m := range msgs {
+        e := fn(m)
+        results = append(results, BatchResult{MsgNum: m, Err: e})
+        if isTransportError(e) {
+            return results, e
+        }
+    }
+    return results, nil
+}
+
+
+// isTransportError reports whether err means the connection is broken.
+func isTransportError(err error) bool {
+    if err == nil {
+        return false
+    }
+    var ne net.Error
+    return errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+        errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed)
+}
+
+
+// Failed returns the results of a batch that have an error.
+func Failed(results []BatchResult) []BatchResult {
+    var failed []BatchResult
+    for _, r := range results {
+        if r.Err != nil {
+            failed = append(failed, r)
+        }
+    }
+    return failed
+}
+
+
+// DeleteMany marks the given messages as deleted and reports the outcome per
+// message. It returns ErrReadOnly for each message if the client is in
+// read-only mode.
+func (c *Client) DeleteMany(msgs []int) ([]BatchResult, error) {
+    return c.runBatch(msgs, c.DELE)
+}
@@ -0,0 +1,237 @@
+// CAPA command and related extensions, see https://tools.ietf.org/html/rfc2449
+package pop3
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// ErrUnsupported is returned when a command needs a capability the server
+// does not announce.
+var ErrUnsupported = errors.New("command not supported by server")
+
+// Capabilities holds the capabilities a server announces in reply to CAPA.
+type Capabilities struct {
+    Top            bool
+    User           bool
+    UIDL           bool
+    Pipelining     bool
+    RespCodes      bool
+    STLS           bool
+    SASL           []string // SASL mechanisms
+    LoginDelay     int      // minimum seconds between logins, -1 if not announced
+    Expire         string   // "NEVER", a number of days, or "" if not announced
+    Implementation string
+
+    Raw []string // every line of the CAPA response, verbatim and in order
+}
+
+
+// Has reports whether the server announced the named capability, e.g.
+// "PIPELINING" or "X-EXPERIMENTAL". The name is compared case-insensitively
+// with the first word of each line, so unknown capabilities work as well.
+func (caps Capabilities) Has(name string) bool {
+    for _, l := range caps.Raw {
+        fs := strings.Fields(l)
+        if len(fs) > 0 && strings.EqualFold(fs[0], name) {
+            return true
+        }
+    }
+    return false
+}
+
+// CAPA asks the server for its capabilities. The result is cached and used
+// by methods that depend on optional extensions, such as pipelining.
+func (c *Client) CAPA() (caps Capabilities, err error) {
+    _, err = c.Cmd("CAPA\r\n")
+    if err != nil {
+        return
+    }
+    lines, err := c.ReadLines()
+    if err != nil {
+        return
+    }
+
+    caps = parseCapabilities(lines)
+    c.caps = &caps
+    return
+}
+
+func parseCapabilities(lines []string) (caps Capabilities) {
+    caps.LoginDelay = -1
+    caps.Raw = lines
+    for _, l := range lines {
+        fs := strings.Fields(l)
+        if len(fs) == 0 {
+            continue
+        }
+        args := fs[1:]
+        switch strings.ToUpper(fs[0]) {
+        case "TOP":
+            caps.Top = true
+        case "USER":
+            caps.User = true
+        case "UIDL":
+            caps.UIDL = true
+        case "PIPELINING":
+            caps.Pipelining = true
+        case "RESP-CODES":
+            caps.RespCodes = true
+        case "STLS":
+            caps.STLS = true
+        case "SASL":
+            caps.SASL = args
+        case "LOGIN-DELAY":
+            if len(args) > 0 {
+                if n, e := strconv.Atoi(args[0]); e == nil {
+                    caps.LoginDelay = n
+                }
+            }
+        case "EXPIRE":
+            if len(args) > 0 {
+                caps.Expire = args[0]
+            }
+        case "IMPLEMENTATION":
+            caps.Implementation = strings.Join(args, " ")
+        }
+    }
+    return
+}
+
+// capabilities returns the cached capabilities, issuing CAPA if none are
+// cached yet. A server which does not understand CAPA is treated as having
+// no optional capabilities.
+func (c *Client) capabilities() (Capabilities, error) {
+    if c.caps != nil {
+        return *c.caps, nil
+    }
+    caps, err := c.CAPA()
+    if err != nil {
+        if _, ok := err.(*Error); !ok {
+            return caps, err
+        }
+        caps = Capabilities{LoginDelay: -1}
+        c.caps = &caps
+    }
+    return caps, nil
+}
+
+
+// SendXCLIENT sends the non-standard XCLIENT command, which passes the
+// original client's connection attributes (e.g. "ADDR", "NAME") to servers
+// behind a proxy. The values are xtext-encoded. It returns ErrUnsupported
+// without sending anything unless the server announces XCLIENT in CAPA.
+func (c *Client) SendXCLIENT(attrs map[string]string) error {
+    caps, err := c.capabilities()
+    if err != nil {
+        return err
+    }
+    if !caps.Has("XCLIENT") {
+        return ErrUnsupported
+    }
+
+    names := make([]string, 0, len(attrs))
+    for name := range attrs {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    var b strings.Builder
+    b.WriteString("XCLIENT")
+    for _, name := range names {
+        fmt.Fprintf(&b, " %s=%s", strings.ToUpper(name), xtext(attrs[name]))
+    }
+
+    _, err = c.Cmd("%s\r\n", b.String())
+    return err
+}
+
+
+// Lang asks the server to use the language given by tag (e.g. "de", or "*"
+// for the client's default) for the text of its responses, as defined in RFC
+// 6856, and returns the language tag the server switched to. It returns
+// ErrUnsupported unless the server announces LANG in CAPA.
+func (c *Client) Lang(tag string) (lang string, err error) {
+    if err = c.requireLang(); err != nil {
+        return
+    }
+    l, err := c.Cmd("LANG %s\r\n", tag)
+    if err != nil {
+        return
+    }
+    if fs := strings.Fields(l); len(fs) > 0 {
+        lang = fs[0]
+    }
+    return
+}
+
+
+// LangList returns the tags of the languages the server supports for its
+// responses (RFC 6856). It returns ErrUnsupported unless the server announces
+// LANG in CAPA.
+func (c *Client) LangList() (tags []string, err error) {
+    if err = c.requireLang(); err != nil {
+        return
+    }
+    if _, err = c.Cmd("LANG\r\n"); err != nil {
+        return
+    }
+    lines, err := c.ReadLines()
+    if err != nil {
+        return
+    }
+    for _, l := range lines {
+        if fs := strings.Fields(l); len(fs) > 0 {
+            tags = append(tags, fs[0])
+        }
+    }
+    return
+}
+
+
+func (c *Client) requireLang() error {
+    caps, err := c.capabilities()
+    if err != nil {
+        return err
+    }
+    if !caps.Has("LANG") {
+        return ErrUnsupported
+    }
+    return nil
+}
+
+
+// xtext encodes s as defined in RFC 3461, section 4.
+func xtext(s string) string {
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        ch := s[i]
+        if ch < '!' || ch > '~' || ch == '+' || ch == '=' {
+            fmt.Fprintf(&b, "+%02X", ch)
+        } else {
+            b.WriteByte(ch)
+        }
+    }
+    return b.String()
+}
+
+
+// DialAndCapa works like Dial, but also issues CAPA right after the greeting
+// and returns the capabilities, which stay cached in the client for methods
+// that depend on them. A server that does not understand CAPA yields empty
+// capabilities rather than an error.
+func DialAndCapa(addr string) (*Client, Capabilities, error) {
+    c, err := Dial(addr)
+    if err != nil {
+        return nil, Capabilities{}, err
+    }
+    caps, err := c.capabilities()
+    if err != nil {
+        c.conn.Close()
+        return nil, Capabilities{}, err
+    }
+    return c, caps, nil
+}
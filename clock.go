@@ -0,0 +1,49 @@
+package pop3
+
+import (
+    "time"
+)
+
+// timer is the part of *time.Timer the package uses, so that tests can
+// replace it.
+type timer interface {
+    Stop() bool
+    Reset(d time.Duration) bool
+}
+
+
+// now returns the current time from the clock of the client. All time
+// measurements and computations of the package go through it, so tests can
+// control time with setClock; deadlines set on the connection necessarily
+// use the real time.
+func (c *Client) now() time.Time {
+    if c.clock != nil {
+        return c.clock()
+    }
+    return time.Now()
+}
+
+
+// afterFunc calls f in its own goroutine after d has passed on the clock of
+// the client, like time.AfterFunc. All timers and waits of the package go
+// through it.
+func (c *Client) afterFunc(d time.Duration, f func()) timer {
+    if c.after != nil {
+        return c.after(d, f)
+    }
+    return time.AfterFunc(d, f)
+}
+
+
+// setClock replaces the clock and the timers of the client, nil restores the
+// real ones. It is meant for tests and must be called before SetIdleTimeout.
+func (c *Client) setClock(clock func() time.Time, after func(time.Duration, func()) timer) {
+    c.clock = clock
+    c.after = after
+    if c.idle != nil {
+        c.idle.mu.Lock()
+        c.idle.now = c.now
+        c.idle.afterFunc = c.afterFunc
+        c.idle.mu.Unlock()
+    }
+}
@@ -0,0 +1,106 @@
+package pop3
+
+import (
+    "context"
+    "crypto/tls"
+    "net"
+    "time"
+)
+
+// DialWithContext works like Dial, but ctx bounds both the connection setup
+// and the reading of the server greeting, so that a server which accepts the
+// connection and never greets cannot block the caller forever. If ctx ends
+// first, its error is returned. Later commands are not affected by ctx.
+func DialWithContext(ctx context.Context, addr string) (*Client, error) {
+    var d net.Dialer
+    return dialClientContext(ctx, func(ctx context.Context) (net.Conn, error) {
+        return d.DialContext(ctx, "tcp", addr)
+    })
+}
+
+
+// DialTLSWithContext is the TLS-secured counterpart of DialWithContext; ctx
+// also bounds the TLS handshake. The param tlsConfig may be nil.
+func DialTLSWithContext(ctx context.Context, addr string, tlsConfig *tls.Config) (*Client, error) {
+    d := tls.Dialer{Config: tlsConfig}
+    c, err := dialClientContext(ctx, func(ctx context.Context) (net.Conn, error) {
+        return d.DialContext(ctx, "tcp", addr)
+    })
+    if err != nil {
+        return nil, err
+    }
+    c.tlsAddr = addr
+    return c, nil
+}
+
+
+// dialClientContext is the context-aware version of dialClient. Reconnect
+// dials without a context.
+func dialClientContext(ctx context.Context, dial func(context.Context) (net.Conn, error)) (*Client, error) {
+    conn, err := dial(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    client := &Client{conn: conn}
+    err = client.withContext(ctx, func() error {
+        return client.greet(conn)
+    })
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    client.dial = func() (net.Conn, error) {
+        return dial(context.Background())
+    }
+    return client, nil
+}
+
+
+// withContext runs f, making pending I/O on the connection fail once ctx is
+// done. If f fails because of that, ctx.Err() is returned. Meanwhile the
+// read timeout is not applied, so that it cannot push the deadline of ctx
+// back. A ctx that can never be done, like context.Background(), leaves the
+// read timeout in place.
+func (c *Client) withContext(ctx context.Context, f func() error) error {
+    if ctx.Done() == nil {
+        return f()
+    }
+    conn := c.conn
+    c.pinned = true
+    c.ctx = ctx
+    defer func() {
+        c.pinned = false
+        c.ctx = nil
+    }()
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+
+    stop := make(chan struct{})
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        select {
+        case <-ctx.Done():
+            // a deadline in the past unblocks pending reads and writes
+            conn.SetDeadline(time.Unix(1, 0))
+        case <-stop:
+        }
+    }()
+
+    err := f()
+    close(stop)
+    <-done
+    conn.SetDeadline(time.Time{})
+
+    // the conn deadline can expire a moment before ctx notices
+    if deadline, ok := ctx.Deadline(); ok && err != nil && !time.Now().Before(deadline) {
+        <-ctx.Done()
+    }
+    if err != nil && ctx.Err() != nil {
+        return ctx.Err()
+    }
+    return err
+}
@@ -0,0 +1,96 @@
+package pop3
+
+import (
+    "sync"
+    "time"
+)
+
+// Coordinator enforces a download rate shared by many clients, e.g. to keep
+// a service draining many mailboxes at once under a global bandwidth cap.
+type Coordinator struct {
+    bucket *tokenBucket
+}
+
+
+// NewCoordinator returns a Coordinator allowing globalBytesPerSec bytes per
+// second, summed over all clients it wraps. If globalBytesPerSec <= 0, the
+// rate is unlimited.
+func NewCoordinator(globalBytesPerSec int64) *Coordinator {
+    if globalBytesPerSec <= 0 {
+        return &Coordinator{}
+    }
+    return &Coordinator{bucket: newTokenBucket(globalBytesPerSec)}
+}
+
+
+// Wrap makes c share the rate limit of the coordinator and returns c. Every
+// read from the connection counts against the limit; a client that gets
+// ahead waits before reading on, measuring time with the clock of c. The
+// wait ends early when the context of a call such as Shutdown is done.
+// Wrap must be called before c is used by more than one goroutine.
+func (co *Coordinator) Wrap(c *Client) *Client {
+    c.limiter = co.bucket
+    return c
+}
+
+
+// tokenBucket is a rate limiter safe for concurrent use. Up to one second of
+// unused rate is saved up for bursts.
+type tokenBucket struct {
+    mu     sync.Mutex
+    rate   float64 // bytes per second
+    tokens float64 // bytes that may be read now, negative when in debt
+    last   time.Time
+}
+
+
+// newTokenBucket returns a bucket for a rate of bytesPerSec, which must be
+// positive.
+func newTokenBucket(bytesPerSec int64) *tokenBucket {
+    rate := float64(bytesPerSec)
+    return &tokenBucket{rate: rate, tokens: rate}
+}
+
+
+// take accounts for n bytes read at the time now and returns how long to
+// wait until the rate allows them.
+func (b *tokenBucket) take(now time.Time, n int) time.Duration {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if !b.last.IsZero() && now.After(b.last) {
+        b.tokens += now.Sub(b.last).Seconds() * b.rate
+        if b.tokens > b.rate {
+            b.tokens = b.rate
+        }
+    }
+    if now.After(b.last) {
+        b.last = now
+    }
+    b.tokens -= float64(n)
+    if b.tokens >= 0 {
+        return 0
+    }
+    return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+
+// sleep waits for d, or until the context of the call in progress, if any
+// (see withContext), is done, and then returns its error.
+func (c *Client) sleep(d time.Duration) error {
+    if d <= 0 {
+        return nil
+    }
+    wake := make(chan struct{})
+    t := c.afterFunc(d, func() { close(wake) })
+    defer t.Stop()
+    var done <-chan struct{}
+    if c.ctx != nil {
+        done = c.ctx.Done()
+    }
+    select {
+    case <-wake:
+        return nil
+    case <-done:
+        return c.ctx.Err()
+    }
+}
@@ -0,0 +1,66 @@
+package pop3
+
+import (
+    "context"
+    "fmt"
+    "strings"
+)
+
+// CredentialProvider supplies the credentials for a login. It is asked
+// again for every login, including those of Reconnect, so that it can hand
+// out fresh OAuth tokens or rotated passwords. mechanism is one of "USER"
+// (USER/PASS, also the default for ""), "APOP" or "XOAUTH2", for which
+// secret is the access token.
+type CredentialProvider interface {
+    Credentials(ctx context.Context) (mechanism, user, secret string, err error)
+}
+
+
+// StaticCredentials is a CredentialProvider which always returns the same
+// credentials.
+type StaticCredentials struct {
+    Mechanism string
+    User      string
+    Secret    string
+}
+
+func (s StaticCredentials) Credentials(ctx context.Context) (mechanism, user, secret string, err error) {
+    return s.Mechanism, s.User, s.Secret, nil
+}
+
+
+// LoginWith logs in with the credentials p provides, asking it with ctx. On
+// success, Reconnect logs in through p as well, asking it with a background
+// context.
+func (c *Client) LoginWith(ctx context.Context, p CredentialProvider) error {
+    mechanism, user, secret, err := p.Credentials(ctx)
+    if err != nil {
+        return err
+    }
+
+    switch strings.ToUpper(mechanism) {
+    case "", "USER":
+        err = c.Auth(user, secret)
+    case "APOP":
+        err = c.APOP(user, secret)
+    case "XOAUTH2":
+        err = c.XOAUTH2(user, secret)
+    default:
+        return fmt.Errorf("%w: login mechanism %q", ErrUnsupported, mechanism)
+    }
+    if err != nil {
+        return err
+    }
+
+    c.reauth = ProviderAuth(p)
+    return nil
+}
+
+
+// ProviderAuth returns a login function which logs in with the credentials
+// p provides, for NewSyncer, CheckCredentials or SetReauth.
+func ProviderAuth(p CredentialProvider) func(*Client) error {
+    return func(c *Client) error {
+        return c.LoginWith(context.Background(), p)
+    }
+}
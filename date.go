@@ -0,0 +1,145 @@
+package pop3
+
+import (
+    "errors"
+    "fmt"
+    "net/mail"
+    "regexp"
+    "strings"
+    "time"
+)
+
+// ErrNoDate is returned when a message has no Date header.
+var ErrNoDate = errors.New("message has no Date header")
+
+
+// MessageDate fetches the header of a message with TOP and returns its Date,
+// parsed leniently (see ParseDate).
+func (c *Client) MessageDate(msg int) (time.Time, error) {
+    text, err := c.TOP(msg, 0)
+    if err != nil {
+        return time.Time{}, err
+    }
+    return headerDate(text)
+}
+
+
+// SentTime returns the Date of the message, parsed leniently (see
+// ParseDate), so that it also works for dates parsemail rejects.
+func (m MailItem) SentTime() (time.Time, error) {
+    if d := m.Header.Get("Date"); d != "" {
+        return ParseDate(d)
+    }
+    if !m.Date.IsZero() {
+        return m.Date, nil
+    }
+    return time.Time{}, ErrNoDate
+}
+
+
+// ClockSkew returns how long after its Date the message reached the server
+// which added the topmost Received header, i.e. the mail server delivering
+// it to the maildrop. This is the delivery delay, plus the difference
+// between the clocks of the sender and that server: a large or negative
+// value points to a misconfigured clock on the sending side. Both dates are
+// parsed leniently (see ParseDate); ok is false if either is missing or
+// cannot be parsed.
+func (m MailItem) ClockSkew() (skew time.Duration, ok bool) {
+    received := m.Header["Received"]
+    if len(received) == 0 {
+        return 0, false
+    }
+    // the date follows the last semicolon of the field
+    i := strings.LastIndexByte(received[0], ';')
+    if i < 0 {
+        return 0, false
+    }
+    arrived, err := ParseDate(received[0][i+1:])
+    if err != nil {
+        return 0, false
+    }
+    sent, err := m.SentTime()
+    if err != nil {
+        return 0, false
+    }
+    return arrived.Sub(sent), true
+}
+
+
+// headerDate returns the Date of a message header as returned by TOP.
+func headerDate(text string) (time.Time, error) {
+    m, err := mail.ReadMessage(strings.NewReader(text + "\n\n"))
+    if err != nil {
+        return time.Time{}, err
+    }
+    d := m.Header.Get("Date")
+    if strings.TrimSpace(d) == "" {
+        return time.Time{}, ErrNoDate
+    }
+    return ParseDate(d)
+}
+
+
+// Layouts tried by ParseDate, after the weekday has been removed and zone
+// names have been replaced by offsets.
+var dateLayouts = []string{
+    "2 Jan 2006 15:04:05 -0700",
+    "2 Jan 2006 15:04 -0700",
+    "2 Jan 06 15:04:05 -0700",
+    "2 Jan 06 15:04 -0700",
+    "2 Jan 2006 15:04:05",
+    "2 Jan 2006 15:04",
+    "2 Jan 06 15:04:05",
+    "Jan 2 15:04:05 2006",
+    "Jan 2 15:04:05 -0700 2006",
+    "2006-01-02T15:04:05Z07:00",
+    "2006-01-02 15:04:05 -0700",
+    "2006-01-02 15:04:05",
+}
+
+
+// Obsolete zone names of RFC 5322 section 4.3, and a few common others.
+var zoneOffsets = map[string]string{
+    "UT": "+0000", "UTC": "+0000", "GMT": "+0000", "Z": "+0000",
+    "EST": "-0500", "EDT": "-0400",
+    "CST": "-0600", "CDT": "-0500",
+    "MST": "-0700", "MDT": "-0600",
+    "PST": "-0800", "PDT": "-0700",
+    "CET": "+0100", "CEST": "+0200",
+    "BST": "+0100", "JST": "+0900",
+}
+
+
+var (
+    dateComment = regexp.MustCompile(`\([^)]*\)`)
+    dateWeekday = regexp.MustCompile(`^(?i)(mon|tue|wed|thu|fri|sat|sun)[a-z]*,?\s*`)
+    dateZone    = regexp.MustCompile(`\b[A-Za-z]{1,4}$`)
+    dateOffset  = regexp.MustCompile(`([+-]\d{2}):?(\d{2})$`)
+)
+
+
+// ParseDate parses the value of a Date header. Besides RFC 5322 dates, it
+// accepts what real mail often contains: missing weekday, seconds or time
+// zone (UTC is assumed), two-digit years, obsolete zone names like "EST",
+// comments like "(PDT)", "+01:00" style offsets and asctime dates.
+func ParseDate(s string) (time.Time, error) {
+    v := dateComment.ReplaceAllString(s, " ")
+    v = strings.Join(strings.Fields(v), " ")
+    v = dateWeekday.ReplaceAllString(v, "")
+    if z := dateZone.FindString(v); z != "" {
+        if off, ok := zoneOffsets[strings.ToUpper(z)]; ok {
+            v = strings.TrimSpace(v[:len(v)-len(z)]) + " " + off
+        }
+    }
+    v = dateOffset.ReplaceAllString(v, "$1$2")
+
+    if t, err := mail.ParseDate(v); err == nil {
+        return t, nil
+    }
+    for _, layout := range dateLayouts {
+        if t, err := time.Parse(layout, v); err == nil {
+            return t, nil
+        }
+    }
+    return time.Time{}, fmt.Errorf("cannot parse date %q", s)
+}
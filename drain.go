@@ -0,0 +1,79 @@
+package pop3
+
+import (
+    "context"
+    "io"
+    "time"
+)
+
+// DrainWithBudget downloads and deletes messages, oldest first, until the
+// maildrop is empty or ctx is done. Each message is streamed to handler; the
+// MailItem passed along only has MsgNum and Size set. A message is marked
+// for deletion only after handler returned nil for it.
+//
+// Before each message, DrainWithBudget checks whether the time left until
+// the deadline of ctx is shorter than the average time a message took so far,
+// and if so stops. The session is then ended with QUIT, which commits the
+// deletions made so far; the client cannot be used afterwards. Stopping
+// because ctx is done is not an error. In read-only mode, it returns
+// ErrReadOnly at once, before anything is sent.
+//
+// The download of each message and its handler run under ctx. If ctx ends in
+// the middle of a message, the rest of it cannot be skipped in time, so the
+// connection is closed without QUIT and ctx.Err() is returned: none of the
+// deletions of the session are committed, and the messages will be
+// downloaded again next time.
+func (c *Client) DrainWithBudget(ctx context.Context, handler func(MailItem, io.Reader) error) (processed int, err error) {
+    if c.readOnly {
+        return 0, ErrReadOnly
+    }
+    msgs, sizes, err := c.ListAll()
+    if err != nil {
+        return
+    }
+
+    // the deadline of ctx is in real time, not that of the client clock
+    var spent time.Duration
+    for i, m := range msgs {
+        if ctx.Err() != nil {
+            break
+        }
+        if deadline, ok := ctx.Deadline(); ok && processed > 0 {
+            if time.Until(deadline) < spent/time.Duration(processed) {
+                break
+            }
+        }
+
+        start := time.Now()
+        err = c.withContext(ctx, func() error {
+            r, err := c.RetrReader(m)
+            if err != nil {
+                return err
+            }
+            err = handler(MailItem{MsgNum: m, Size: sizes[i]}, r)
+            if e := r.Close(); err == nil {
+                err = e
+            }
+            return err
+        })
+        if err != nil && ctx.Err() != nil {
+            // the connection is out of step with the server
+            closeClient(c)
+            return processed, ctx.Err()
+        }
+        if err != nil || ctx.Err() != nil {
+            break
+        }
+
+        if err = c.DELE(m); err != nil {
+            break
+        }
+        processed++
+        spent += time.Since(start)
+    }
+
+    if e := c.QUIT(); err == nil {
+        err = e
+    }
+    return
+}
@@ -0,0 +1,175 @@
+package pop3
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/base64"
+    "errors"
+    "io"
+    "mime"
+    "mime/multipart"
+    "mime/quotedprintable"
+    "net/textproto"
+    "strings"
+)
+
+// ErrNotDSN is returned when a message has no message/delivery-status part.
+var ErrNotDSN = errors.New("message is not a delivery status notification")
+
+// DeliveryStatus holds the machine-readable part of a delivery status
+// notification (RFC 3464), as sent in bounces. The fields of the first
+// recipient are promoted for the common single-recipient case.
+type DeliveryStatus struct {
+    ReportingMTA string // e.g. "mx.example.com", without the "dns;" type
+    RecipientStatus
+    Recipients []RecipientStatus
+}
+
+// RecipientStatus is the delivery status for one recipient.
+type RecipientStatus struct {
+    FinalRecipient string // address, without the "rfc822;" type
+    Action         string // "failed", "delayed", "delivered", "relayed" or "expanded"
+    Status         string // status code (RFC 3463), e.g. "5.1.1"
+    DiagnosticCode string // e.g. "550 5.1.1 User unknown", without the "smtp;" type
+}
+
+
+// DeliveryStatus parses the message/delivery-status part of the message.
+// Items from GetMailWithRaw are fully supported. Otherwise only status parts
+// attached to multipart/mixed messages are found (and their Data consumed):
+// the parts of multipart/report messages, the usual form of DSNs, are not
+// recognized by the MIME parser GetMail uses. Client.DeliveryStatus works
+// for any message on the server.
+func (m MailItem) DeliveryStatus() (*DeliveryStatus, error) {
+    if len(m.Raw) > 0 {
+        return ParseDeliveryStatus(bytes.NewReader(m.Raw))
+    }
+    for _, a := range m.Attachments {
+        if strings.EqualFold(a.ContentType, "message/delivery-status") {
+            return parseStatusFields(a.Data)
+        }
+    }
+    return nil, ErrNotDSN
+}
+
+
+// DeliveryStatus downloads the given message and parses its
+// message/delivery-status part, see ParseDeliveryStatus.
+func (c *Client) DeliveryStatus(msg int) (ds *DeliveryStatus, err error) {
+    r, err := c.RetrReader(msg)
+    if err != nil {
+        return
+    }
+    defer func() {
+        // a transfer error explains a missing status part
+        if e := r.Close(); e != nil && (err == nil || err == ErrNotDSN) {
+            err = e
+        }
+    }()
+    return ParseDeliveryStatus(r)
+}
+
+
+// ParseDeliveryStatus reads a raw message and parses its first
+// message/delivery-status part, wherever it is in the MIME structure. It
+// returns ErrNotDSN if there is none.
+func ParseDeliveryStatus(r io.Reader) (*DeliveryStatus, error) {
+    tp := textproto.NewReader(bufio.NewReader(r))
+    header, err := tp.ReadMIMEHeader()
+    if err != nil && err != io.EOF {
+        return nil, err
+    }
+    part, err := findPart(header, tp.R, "message/delivery-status")
+    if err != nil {
+        return nil, err
+    }
+    return parseStatusFields(part)
+}
+
+
+// findPart returns the decoded body of the first part of the given media
+// type in the part with the given header and body, or ErrNotDSN.
+func findPart(header textproto.MIMEHeader, body io.Reader, want string) (io.Reader, error) {
+    mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+    if err != nil {
+        return nil, ErrNotDSN
+    }
+    if mediaType == want {
+        return decodeTransfer(header, body), nil
+    }
+    if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+        return nil, ErrNotDSN
+    }
+
+    mr := multipart.NewReader(body, params["boundary"])
+    for {
+        p, err := mr.NextRawPart()
+        if err == io.EOF {
+            return nil, ErrNotDSN
+        }
+        if err != nil {
+            return nil, err
+        }
+        found, err := findPart(p.Header, p, want)
+        if err != ErrNotDSN {
+            return found, err
+        }
+    }
+}
+
+
+// decodeTransfer returns a reader removing the Content-Transfer-Encoding
+// given in header from body.
+func decodeTransfer(header textproto.MIMEHeader, body io.Reader) io.Reader {
+    switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+    case "base64":
+        return base64.NewDecoder(base64.StdEncoding, body)
+    case "quoted-printable":
+        return quotedprintable.NewReader(body)
+    }
+    return body
+}
+
+
+// parseStatusFields parses the body of a message/delivery-status part: a
+// block of per-message fields, then a block per recipient.
+func parseStatusFields(r io.Reader) (*DeliveryStatus, error) {
+    tp := textproto.NewReader(bufio.NewReader(r))
+    msgFields, err := tp.ReadMIMEHeader()
+    if err != nil && err != io.EOF {
+        return nil, err
+    }
+    ds := &DeliveryStatus{ReportingMTA: typedValue(msgFields.Get("Reporting-MTA"))}
+
+    for err == nil {
+        var fields textproto.MIMEHeader
+        fields, err = tp.ReadMIMEHeader()
+        if err != nil && err != io.EOF {
+            return nil, err
+        }
+        if len(fields) == 0 {
+            continue
+        }
+        ds.Recipients = append(ds.Recipients, RecipientStatus{
+            FinalRecipient: typedValue(fields.Get("Final-Recipient")),
+            Action:         strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+            Status:         strings.TrimSpace(fields.Get("Status")),
+            DiagnosticCode: typedValue(fields.Get("Diagnostic-Code")),
+        })
+    }
+
+    if len(ds.Recipients) == 0 {
+        return nil, ErrNotDSN
+    }
+    ds.RecipientStatus = ds.Recipients[0]
+    return ds, nil
+}
+
+
+// typedValue strips the type from a field value like "rfc822; a@b.example".
+func typedValue(v string) string {
+    if i := strings.IndexByte(v, ';'); i >= 0 {
+        v = v[i+1:]
+    }
+    return strings.TrimSpace(v)
+}
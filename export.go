@@ -0,0 +1,114 @@
+package pop3
+
+import (
+    "compress/gzip"
+    "encoding/json"
+    "io"
+    "net/mail"
+    "os"
+    "strings"
+    "time"
+)
+
+// SaveMail saves the given message to the file at path, in the form RetrTo
+// writes it. If the download fails, the file is removed.
+func (c *Client) SaveMail(msg int, path string) error {
+    return c.saveFile(path, func(f io.Writer) error {
+        _, err := c.RetrTo(msg, f)
+        return err
+    })
+}
+
+
+// SaveMailGz works like SaveMail, but compresses the message with gzip while
+// it is downloaded. The suffix ".gz" is appended to path unless it is
+// already there.
+func (c *Client) SaveMailGz(msg int, path string) error {
+    if !strings.HasSuffix(path, ".gz") {
+        path += ".gz"
+    }
+    return c.saveFile(path, func(f io.Writer) error {
+        zw := gzip.NewWriter(f)
+        if _, err := c.RetrTo(msg, zw); err != nil {
+            zw.Close()
+            return err
+        }
+        return zw.Close()
+    })
+}
+
+
+// saveFile creates the file at path and fills it with write, removing the
+// file again if something fails.
+func (c *Client) saveFile(path string, write func(io.Writer) error) error {
+    f, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+
+    err = write(f)
+    if e := f.Close(); err == nil {
+        err = e
+    }
+    if err != nil {
+        os.Remove(path)
+    }
+    return err
+}
+
+
+// messageMetadata is the JSON form of a message in ExportMetadataJSON.
+type messageMetadata struct {
+    UID            string   `json:"uid,omitempty"`
+    MsgNum         int      `json:"msgNum"`
+    Size           int      `json:"size"`
+    Subject        string   `json:"subject"`
+    From           []string `json:"from"`
+    To             []string `json:"to"`
+    Date           string   `json:"date,omitempty"` // RFC 3339
+    HasAttachments bool     `json:"hasAttachments"`
+}
+
+
+// ExportMetadataJSON writes one JSON object per message to w, as
+// newline-delimited JSON, with the fields uid, msgNum, size, subject, from
+// and to (lists of addresses as in "Name <a@example.com>"), date (RFC 3339,
+// omitted if the message has no usable date) and hasAttachments (guessed as
+// by HasAttachments). The headers are fetched with TOP, pipelined if the
+// server supports it, and each object is written as soon as its header
+// arrives, so memory use does not grow with the size of the maildrop.
+func (c *Client) ExportMetadataJSON(w io.Writer) error {
+    enc := json.NewEncoder(w)
+    enc.SetEscapeHTML(false) // keep the angle brackets of addresses readable
+    return c.headerEach(false, func(item MailItem) error {
+        meta := messageMetadata{
+            UID:            item.UID,
+            MsgNum:         item.MsgNum,
+            Size:           item.Size,
+            Subject:        item.Subject,
+            From:           make([]string, 0, len(item.From)),
+            To:             make([]string, 0, len(item.To)),
+            HasAttachments: headerHasAttachments(item.Header),
+        }
+        for _, a := range item.From {
+            meta.From = append(meta.From, plainAddress(a))
+        }
+        for _, a := range item.To {
+            meta.To = append(meta.To, plainAddress(a))
+        }
+        if !item.Date.IsZero() {
+            meta.Date = item.Date.Format(time.RFC3339)
+        }
+        return enc.Encode(meta)
+    })
+}
+
+
+// plainAddress formats an address like mail.Address.String, but leaves the
+// name as it is instead of encoding non-ASCII names (RFC 2047).
+func plainAddress(a *mail.Address) string {
+    if a.Name == "" {
+        return "<" + a.Address + ">"
+    }
+    return a.Name + " <" + a.Address + ">"
+}
@@ -1,5 +1,7 @@
 module github.com/m3ng9i/go-pop3
 
-go 1.12
+go 1.21
 
 require github.com/m3ng9i/parsemail v0.0.3
+
+require golang.org/x/text v0.3.2 // indirect
@@ -0,0 +1,110 @@
+package pop3
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/m3ng9i/parsemail"
+)
+
+// HarvestAttachments downloads every message, oldest first, and saves the
+// attachments for which filter returns true into dir, returning the paths
+// of the written files. A nil filter keeps all attachments. Each message is
+// spooled to a temporary file, so the connection is not held while it is
+// parsed; the parser still reads the whole message and holds all its
+// attachments in memory, so memory use grows with the size of the largest
+// message. The filter must not read the attachment data.
+//
+// File names come from the attachments, reduced to their base name; if a
+// file of that name exists, a number is added ("report (1).pdf"). The
+// outcome for each message, e.g. a message that cannot be parsed, is
+// reported in results; only a transport error stops the harvest and is
+// returned as err.
+func (c *Client) HarvestAttachments(dir string, filter func(MailItem, parsemail.Attachment) bool) (paths []string, results []BatchResult, err error) {
+    msgs, sizes, err := c.ListAll()
+    if err != nil {
+        return
+    }
+
+    size := make(map[int]int, len(msgs))
+    for i, m := range msgs {
+        size[m] = sizes[i]
+    }
+
+    results, err = c.runBatch(msgs, func(m int) error {
+        written, e := c.harvestMessage(MailItem{MsgNum: m, Size: size[m]}, dir, filter)
+        paths = append(paths, written...)
+        return e
+    })
+    return
+}
+
+
+// harvestMessage saves the attachments of one message for HarvestAttachments.
+func (c *Client) harvestMessage(item MailItem, dir string, filter func(MailItem, parsemail.Attachment) bool) (paths []string, err error) {
+    spool, _, err := c.RetrToTempFile(item.MsgNum)
+    if err != nil {
+        return
+    }
+    defer os.Remove(spool.Name())
+    defer spool.Close()
+
+    email, err := parsemail.Parse(spool)
+    if err != nil {
+        return
+    }
+    item.Email = email
+    item.Complete = true
+
+    for _, a := range email.Attachments {
+        if filter != nil && !filter(item, a) {
+            continue
+        }
+        var path string
+        path, err = saveAttachment(dir, a)
+        if err != nil {
+            return
+        }
+        paths = append(paths, path)
+    }
+    return
+}
+
+
+// saveAttachment writes an attachment into dir under a name not used yet.
+func saveAttachment(dir string, a parsemail.Attachment) (path string, err error) {
+    name := filepath.Base(strings.Replace(a.Filename, "\\", "/", -1))
+    if name == "." || name == "/" || name == "" {
+        name = "attachment"
+    }
+    ext := filepath.Ext(name)
+    base := strings.TrimSuffix(name, ext)
+
+    var f *os.File
+    for n := 0; ; n++ {
+        path = filepath.Join(dir, name)
+        if n > 0 {
+            path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, ext))
+        }
+        f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+        if err == nil {
+            break
+        }
+        if !os.IsExist(err) {
+            return "", err
+        }
+    }
+
+    _, err = io.Copy(f, a.Data)
+    if e := f.Close(); err == nil {
+        err = e
+    }
+    if err != nil {
+        os.Remove(path)
+        return "", err
+    }
+    return path, nil
+}
@@ -0,0 +1,192 @@
+package pop3
+
+import (
+    "bufio"
+    "io"
+    "mime"
+    "net/mail"
+    "net/textproto"
+    "strings"
+
+    "github.com/m3ng9i/parsemail"
+)
+
+// SetVerifyHeaders turns header verification on or off. Some servers cut
+// off long headers in the response to TOP, which then lacks the blank line
+// separating the header from the body. With verification on, Headers,
+// GetInfo and GetList check for that line and fetch truncated messages again
+// with RETR to get the complete header. Stats.HeaderFallbacks counts how
+// often this happened. Messages without a body may also lack the blank line,
+// which costs a needless but small RETR.
+func (c *Client) SetVerifyHeaders(on bool) {
+    c.verifyHeaders = on
+}
+
+
+// Headers returns the header of the given message, up to but not including
+// the blank line which ends it, using TOP msg 0. If header verification is
+// on (see SetVerifyHeaders) and the header was cut off, it is taken from the
+// whole message instead, and fellBack is true.
+func (c *Client) Headers(msg int) (header string, fellBack bool, err error) {
+    text, fellBack, err := c.topVerified(msg, 0)
+    if err != nil {
+        return
+    }
+    if i := strings.Index(text, "\n\n"); i >= 0 {
+        text = text[:i]
+    }
+    return strings.TrimSuffix(text, "\n"), fellBack, nil
+}
+
+
+// RawHeaders returns the header of the given message verbatim, as fetched
+// with TOP msg 0: dot-stuffing is removed, but line endings and folding are
+// kept, up to and including the blank line which ends the header, e.g. for
+// DKIM verification, which needs the exact bytes.
+func (c *Client) RawHeaders(msg int) (header string, err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return
+    }
+    if err = c.checkMsg(msg); err != nil {
+        return
+    }
+    if _, err = c.Cmd("TOP %d 0\r\n", msg); err != nil {
+        return
+    }
+    r := c.newDotReader()
+    c.active = r
+    defer func() {
+        if e := r.Close(); err == nil {
+            err = e
+        }
+    }()
+
+    max := c.maxLine
+    if max <= 0 {
+        max = defaultMaxLineLength
+    }
+    var b strings.Builder
+    br := bufio.NewReader(r)
+    lineLen := 0
+    for {
+        l, e := br.ReadSlice('\n')
+        b.Write(l)
+        if lineLen += len(l); lineLen > max {
+            return "", ErrLineTooLong
+        }
+        if e == bufio.ErrBufferFull {
+            continue
+        }
+        if e == io.EOF {
+            break
+        }
+        if e != nil {
+            return "", e
+        }
+        if lineLen <= 2 && (string(l) == "\r\n" || string(l) == "\n") {
+            break
+        }
+        lineLen = 0
+    }
+    return b.String(), nil
+}
+
+
+// topVerified works like TOP, but with header verification on, it falls back
+// to RETR when the header is cut off.
+func (c *Client) topVerified(msg, n int) (text string, fellBack bool, err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return
+    }
+    if err = c.checkMsg(msg); err != nil {
+        return
+    }
+    if _, err = c.Cmd("TOP %d %d\r\n", msg, n); err != nil {
+        return
+    }
+    lines, err := c.ReadLines()
+    if err != nil {
+        return
+    }
+    if !c.verifyHeaders || hasHeaderEnd(lines) {
+        return strings.Join(lines, "\n"), false, nil
+    }
+
+    if text, err = c.RETR(msg); err != nil {
+        return
+    }
+    c.stats.HeaderFallbacks++
+    return text, true, nil
+}
+
+
+// hasHeaderEnd reports whether the lines of a TOP response contain the blank
+// line ending the header.
+func hasHeaderEnd(lines []string) bool {
+    for _, l := range lines {
+        if l == "" {
+            return true
+        }
+    }
+    return false
+}
+
+
+// parseHeader parses the header at the start of text. If parsemail rejects
+// it, the header is parsed again leniently: lines which are not valid header
+// fields are skipped and fields that cannot be parsed are left empty. The
+// parsemail error is then returned as warning, along with what could be
+// parsed. The Date is parsed leniently in any case, see ParseDate.
+func parseHeader(text string) (email parsemail.Email, warning error) {
+    email, warning = parsemail.ParseHeader(strings.NewReader(text))
+    if warning != nil {
+        email = lenientHeader(text)
+    }
+    if email.Date.IsZero() {
+        if d, e := ParseDate(email.Header.Get("Date")); e == nil {
+            email.Date = d
+        }
+    }
+    return
+}
+
+
+// lenientHeader parses a header as well as it can, see parseHeader.
+func lenientHeader(text string) (email parsemail.Email) {
+    email.Header = make(mail.Header)
+    dec := mime.WordDecoder{}
+    add := func(field string) {
+        i := strings.IndexByte(field, ':')
+        if i <= 0 || strings.ContainsAny(field[:i], " \t") {
+            return
+        }
+        key := textproto.CanonicalMIMEHeaderKey(field[:i])
+        value := strings.TrimSpace(field[i+1:])
+        if d, err := dec.DecodeHeader(value); err == nil {
+            value = d
+        }
+        email.Header[key] = append(email.Header[key], value)
+    }
+
+    var field string
+    for _, l := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+        if l == "" {
+            break
+        }
+        if (l[0] == ' ' || l[0] == '\t') && field != "" {
+            field += " " + strings.TrimSpace(l)
+            continue
+        }
+        add(field)
+        field = l
+    }
+    add(field)
+
+    h := email.Header
+    email.Subject = h.Get("Subject")
+    email.From, _ = h.AddressList("From")
+    email.To, _ = h.AddressList("To")
+    email.Cc, _ = h.AddressList("Cc")
+    email.MessageID = strings.Trim(h.Get("Message-Id"), "<> ")
+    return
+}
@@ -0,0 +1,136 @@
+package pop3
+
+import (
+    "errors"
+    "net"
+    "sync"
+    "time"
+)
+
+// ErrIdleTimeout is returned for commands sent after the connection was
+// closed by the idle timeout, see SetIdleTimeout.
+var ErrIdleTimeout = errors.New("connection closed after idle timeout")
+
+// idleTimer closes the connection of a client that has not been used for a
+// while. Its fields are shared with the timer goroutine and guarded by mu.
+type idleTimer struct {
+    mu        sync.Mutex
+    d         time.Duration
+    timer     timer
+    conn      net.Conn  // connection to close, nil while not connected
+    now       func() time.Time
+    afterFunc func(time.Duration, func()) timer
+    quit      string    // QUIT with the line ending of the client
+    last      time.Time // last read from or write to the connection
+    busy      int       // reads and writes in progress
+    expired   bool      // conn has been closed by the timer
+}
+
+
+// SetIdleTimeout makes the client end the session with QUIT and close the
+// connection when it has not sent or received anything for d, so that idle
+// clients (e.g. in a HostPool) do not hold server resources and are not
+// dropped by the server without notice. The client then reports StateClosed,
+// which makes HostPool discard it, and commands fail with ErrIdleTimeout
+// until Reconnect. d <= 0 turns the timeout off.
+//
+// The package sends no keepalive of its own: every command resets the
+// timer, so a periodic NOOP sent by the application keeps the connection
+// open. A reader returned by RetrReader which is not read from for d is cut
+// off as well; waiting for the server does not count as idle.
+func (c *Client) SetIdleTimeout(d time.Duration) {
+    if c.idle != nil {
+        c.idle.stop()
+        c.idle = nil
+    }
+    if d <= 0 {
+        return
+    }
+    c.idle = &idleTimer{d: d, now: c.now, afterFunc: c.afterFunc, quit: c.command("QUIT\r\n")}
+    if c.conn != nil && c.state != StateClosed {
+        c.idle.start(c.conn)
+    }
+}
+
+
+// start watches conn, replacing the previous connection.
+func (t *idleTimer) start(conn net.Conn) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.conn = conn
+    t.last = t.now()
+    t.busy = 0
+    t.expired = false
+    if t.timer == nil {
+        t.timer = t.afterFunc(t.d, t.fire)
+    } else {
+        t.timer.Reset(t.d)
+    }
+}
+
+
+func (t *idleTimer) stop() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.timer != nil {
+        t.timer.Stop()
+    }
+    t.conn = nil
+}
+
+
+// How long fire waits for the server to take the QUIT.
+const idleQuitTimeout = 5 * time.Second
+
+
+// fire closes the connection unless it has been used within the timeout.
+func (t *idleTimer) fire() {
+    t.mu.Lock()
+    if t.expired || t.conn == nil {
+        t.mu.Unlock()
+        return
+    }
+    if t.busy > 0 {
+        t.timer.Reset(t.d)
+        t.mu.Unlock()
+        return
+    }
+    if since := t.now().Sub(t.last); since < t.d {
+        t.timer.Reset(t.d - since)
+        t.mu.Unlock()
+        return
+    }
+    // once expired is set, no command is sent any more, so the QUIT can be
+    // sent without holding the lock
+    t.expired = true
+    conn, quit := t.conn, t.quit
+    t.mu.Unlock()
+
+    conn.SetWriteDeadline(time.Now().Add(idleQuitTimeout))
+    conn.Write([]byte(quit))
+    conn.Close()
+}
+
+
+// idleExpired reports whether the idle timeout has closed the connection.
+func (c *Client) idleExpired() bool {
+    if c.idle == nil {
+        return false
+    }
+    c.idle.mu.Lock()
+    defer c.idle.mu.Unlock()
+    return c.idle.expired
+}
+
+
+// idleUse records a read from or write to the connection, calling it with +1
+// before and -1 after the I/O.
+func (c *Client) idleUse(delta int) {
+    if c.idle == nil {
+        return
+    }
+    c.idle.mu.Lock()
+    c.idle.last = c.idle.now()
+    c.idle.busy += delta
+    c.idle.mu.Unlock()
+}
@@ -0,0 +1,308 @@
+package pop3
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "io"
+    "os"
+)
+
+// ErrIterClosed is returned by MessageIter.Retr after Close.
+var ErrIterClosed = errors.New("iterator is closed")
+
+// ErrPrefetching is returned for commands sent over a connection which a
+// prefetching MessageIter is using, see MessagesPrefetch.
+var ErrPrefetching = errors.New("connection is in use by a prefetching iterator")
+
+// Messages larger than this are spooled to a temporary file by the
+// prefetcher instead of being held in memory.
+const prefetchSpoolSize = 1 << 20
+
+
+// MessageIter steps through the messages of the maildrop, oldest first. It
+// is created by Client.Messages.
+type MessageIter struct {
+    c     *Client
+    src   *Client // client the prefetcher fetches with
+    own   bool    // src has a session of its own, which Close ends
+    msgs  []int
+    sizes []int
+    i     int // index of the current message, -1 before the first Next
+    err   error
+
+    prefetch int                // bodies fetched ahead, 0 means none
+    fetched  chan prefetchedMsg // filled by the prefetcher in message order
+    done     chan struct{}      // closed to stop the prefetcher
+    stopped  chan struct{}      // closed when the prefetcher has returned
+}
+
+type prefetchedMsg struct {
+    msg int
+    r   io.ReadCloser
+    err error
+}
+
+// IterOption configures a MessageIter.
+type IterOption func(*MessageIter)
+
+
+// MessagesPrefetch makes the iterator fetch up to n message bodies ahead on
+// a background goroutine, so that the download of the next messages overlaps
+// with the processing of the current one. Bodies are kept in memory, or in a
+// temporary file if they are larger than 1 MiB. Every message is fetched,
+// whether Retr is called for it or not.
+//
+// If the client can reconnect (it was dialed and logged in with a reauth
+// function, see Reconnect), the prefetcher opens a second session of its
+// own, and the client remains free for other commands. Many servers lock the
+// maildrop for the duration of a session and refuse the second one; the
+// prefetcher then shares the client's connection, and until the iterator is
+// closed, commands sent with the client fail with ErrPrefetching, while
+// State and Stats report the session as it was before the iteration.
+func MessagesPrefetch(n int) IterOption {
+    return func(it *MessageIter) {
+        if n > 0 {
+            it.prefetch = n
+        }
+    }
+}
+
+
+// Messages returns an iterator over the messages of the maildrop, from the
+// lowest message number up. The list of messages is taken with LIST when
+// the iterator is created. Close must be called when the iteration is done.
+func (c *Client) Messages(opts ...IterOption) (*MessageIter, error) {
+    msgs, sizes, err := c.ListAll()
+    if err != nil {
+        return nil, err
+    }
+
+    it := &MessageIter{c: c, msgs: msgs, sizes: sizes, i: -1}
+    for _, opt := range opts {
+        opt(it)
+    }
+    if it.prefetch > 0 {
+        it.fetched = make(chan prefetchedMsg, it.prefetch-1)
+        it.done = make(chan struct{})
+        it.stopped = make(chan struct{})
+        it.src, it.own = c.prefetchSource()
+        c.prefetching = it
+        go it.prefetcher()
+    }
+    return it, nil
+}
+
+
+// prefetchSource returns the client for a prefetcher: a clone with a second
+// session if one can be opened, otherwise a copy of c sharing its
+// connection, in which case own is false. The copy reads the connection with
+// a reader of its own, so that nothing of c is touched until the session is
+// handed back with takeSession.
+func (c *Client) prefetchSource() (src *Client, own bool) {
+    if c.dial != nil && c.reauth != nil {
+        clone := c.ConfigClone()
+        if err := clone.Reconnect(); err == nil && clone.State() == StateTransaction {
+            return clone, true
+        }
+        if clone.conn != nil {
+            closeClient(clone)
+        }
+    }
+    src = new(Client)
+    *src = *c
+    src.takeReader(c.bin)
+    return src, false
+}
+
+
+// takeSession takes back the session of the shared connection from src, the
+// copy made by prefetchSource, once its prefetcher has returned.
+func (c *Client) takeSession(src *Client) {
+    c.state = src.state
+    c.lastCode = src.lastCode
+    c.caps = src.caps
+    c.uids = src.uids
+    c.warmedUp = src.warmedUp
+    c.noUIDL = src.noUIDL
+    c.stats = src.stats
+    c.deleted = src.deleted
+    c.msgMax = src.msgMax
+    c.takeReader(src.bin)
+}
+
+
+// takeReader makes c read the connection through a meter of its own,
+// starting with what b has buffered.
+func (c *Client) takeReader(b *bufio.Reader) {
+    var r io.Reader = meter{c}
+    if n := b.Buffered(); n > 0 {
+        buffered, _ := b.Peek(n)
+        r = io.MultiReader(bytes.NewReader(append([]byte(nil), buffered...)), r)
+    }
+    c.bin = bufio.NewReader(r)
+}
+
+
+// Next advances to the next message and reports whether there is one.
+func (it *MessageIter) Next() bool {
+    if it.err != nil || it.i >= len(it.msgs) {
+        return false
+    }
+    it.i++
+    return it.i < len(it.msgs)
+}
+
+
+// Item returns the current message, with MsgNum and Size set.
+func (it *MessageIter) Item() MailItem {
+    if it.i < 0 || it.i >= len(it.msgs) {
+        return MailItem{}
+    }
+    return MailItem{MsgNum: it.msgs[it.i], Size: it.sizes[it.i]}
+}
+
+
+// Retr returns the body of the current message. The reader must be closed
+// before Next is called again. Without prefetching, the reader streams the
+// message from the connection like RetrReader.
+func (it *MessageIter) Retr() (io.ReadCloser, error) {
+    if it.err != nil {
+        return nil, it.err
+    }
+    if it.i < 0 || it.i >= len(it.msgs) {
+        return nil, errors.New("no current message")
+    }
+    msg := it.msgs[it.i]
+    if it.fetched == nil {
+        return it.c.RetrReader(msg)
+    }
+
+    // skip what was fetched for messages passed over by Next
+    for p := range it.fetched {
+        if isTransportError(p.err) {
+            it.err = p.err
+        }
+        if p.msg == msg {
+            return p.r, p.err
+        }
+        if p.r != nil {
+            p.r.Close()
+        }
+    }
+    if it.err != nil {
+        return nil, it.err
+    }
+    return nil, ErrIterClosed
+}
+
+
+// Err returns the error which ended the iteration, if any.
+func (it *MessageIter) Err() error {
+    return it.err
+}
+
+
+// Close stops the prefetcher, if any, and discards the bodies it fetched
+// but were not returned. It waits for a transfer in progress to complete, so
+// the client can be used again afterwards.
+func (it *MessageIter) Close() error {
+    it.i = len(it.msgs)
+    if it.done == nil {
+        return nil
+    }
+    select {
+    case <-it.done:
+        return nil
+    default:
+    }
+
+    close(it.done)
+    for p := range it.fetched {
+        if p.r != nil {
+            p.r.Close()
+        }
+    }
+    <-it.stopped
+
+    if it.c.prefetching == it {
+        it.c.prefetching = nil
+    }
+    if it.own {
+        it.src.QUIT()
+    } else {
+        it.c.takeSession(it.src)
+    }
+    return nil
+}
+
+
+// prefetcher fetches every message in turn and hands the bodies over in
+// fetched until all are fetched, a transport error occurs or done is closed.
+func (it *MessageIter) prefetcher() {
+    defer close(it.stopped)
+    defer close(it.fetched)
+
+    for i, msg := range it.msgs {
+        select {
+        case <-it.done:
+            return
+        default:
+        }
+
+        r, err := it.src.spoolMessage(msg, it.sizes[i])
+        select {
+        case it.fetched <- prefetchedMsg{msg: msg, r: r, err: err}:
+        case <-it.done:
+            if r != nil {
+                r.Close()
+            }
+            return
+        }
+        if isTransportError(err) {
+            return
+        }
+    }
+}
+
+
+// checkShared returns ErrPrefetching if a prefetcher is using the connection.
+// It is checked before anything touches the connection.
+func (c *Client) checkShared() error {
+    if it := c.prefetching; it != nil && !it.own {
+        return ErrPrefetching
+    }
+    return nil
+}
+
+
+// spoolMessage retrieves a message into memory, or into a temporary file if
+// it is larger than prefetchSpoolSize, and returns a reader over the copy.
+func (c *Client) spoolMessage(msg, size int) (io.ReadCloser, error) {
+    if size <= prefetchSpoolSize {
+        var b bytes.Buffer
+        if _, err := c.RetrTo(msg, &b); err != nil {
+            return nil, err
+        }
+        return io.NopCloser(&b), nil
+    }
+
+    f, _, err := c.RetrToTempFile(msg)
+    if err != nil {
+        return nil, err
+    }
+    return &spoolFile{f}, nil
+}
+
+
+// spoolFile is a temporary file from RetrToTempFile which is removed when it
+// is closed, also where the file could not be removed while open.
+type spoolFile struct {
+    *os.File
+}
+
+func (f *spoolFile) Close() error {
+    err := f.File.Close()
+    os.Remove(f.Name())
+    return err
+}
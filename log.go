@@ -0,0 +1,113 @@
+package pop3
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "strings"
+    "time"
+)
+
+// SetLogger sets a structured logger for the client, nil turns logging off.
+// Every command is logged at debug level with the fields "command" (with
+// passwords, APOP digests and AUTH credentials redacted), "status", "bytes"
+// (received for the status line) and "latency". Connecting, logging in and
+// QUIT are logged at info level, errors worth a retry (a broken connection,
+// or a response code of IN-USE, LOGIN-DELAY or SYS/TEMP) at warning level.
+func (c *Client) SetLogger(l *slog.Logger) {
+    c.logger = l
+}
+
+
+// SetName names the client, e.g. after its mailbox, to tell the logs of
+// many clients apart: every log record then has the field "client" with the
+// name. The name is kept by Reconnect and copied by ConfigClone.
+func (c *Client) SetName(name string) {
+    c.name = name
+}
+
+
+// Name returns the name set with SetName.
+func (c *Client) Name() string {
+    return c.name
+}
+
+
+// logCmd logs the outcome of a command sent by Cmd, text being the response
+// text after the status.
+func (c *Client) logCmd(cmd, text string, err error, start time.Time, bytes int64) {
+    logger := c.logger
+    if logger == nil {
+        return
+    }
+    if c.name != "" {
+        logger = logger.With(slog.String("client", c.name))
+    }
+
+    status := "+OK"
+    var e *Error
+    switch {
+    case errors.As(err, &e):
+        status = "-ERR"
+    case err != nil:
+        status = ""
+    }
+    attrs := []slog.Attr{
+        slog.String("command", redact(cmd)),
+        slog.String("status", status),
+        slog.Int64("bytes", bytes),
+        slog.Duration("latency", c.now().Sub(start)),
+    }
+    if err != nil {
+        attrs = append(attrs, slog.String("error", err.Error()))
+    }
+
+    ctx := context.Background()
+    logger.LogAttrs(ctx, slog.LevelDebug, "pop3 command", attrs...)
+    if retryable(err) {
+        logger.LogAttrs(ctx, slog.LevelWarn, "pop3 retryable error", attrs...)
+    }
+    if err != nil {
+        return
+    }
+    switch verb(cmd) {
+    case "":
+        logger.LogAttrs(ctx, slog.LevelInfo, "pop3 connected", slog.String("greeting", text))
+    case "PASS", "APOP", "AUTH":
+        logger.LogAttrs(ctx, slog.LevelInfo, "pop3 authenticated")
+    case "QUIT":
+        logger.LogAttrs(ctx, slog.LevelInfo, "pop3 quit")
+    }
+}
+
+
+// redact removes secrets from a command line for logging.
+func redact(cmd string) string {
+    cmd = strings.TrimRight(cmd, "\r\n")
+    fs := strings.Fields(cmd)
+    switch verb(cmd) {
+    case "PASS":
+        return "PASS ***"
+    case "APOP", "AUTH":
+        // the user name or mechanism is no secret
+        if len(fs) > 1 {
+            return fs[0] + " " + fs[1] + " ***"
+        }
+        return fs[0] + " ***"
+    }
+    return cmd
+}
+
+
+// retryable reports whether err is worth retrying later.
+func retryable(err error) bool {
+    var e *Error
+    if errors.As(err, &e) {
+        switch e.Code {
+        case "IN-USE", "LOGIN-DELAY", "SYS/TEMP":
+            return true
+        }
+        return false
+    }
+    return isTransportError(err)
+}
@@ -0,0 +1,278 @@
+// Helpers for inspecting fetched messages.
+package pop3
+
+import (
+    "bytes"
+    "errors"
+    "mime"
+    "strings"
+    "unicode/utf8"
+
+    "github.com/m3ng9i/parsemail"
+)
+
+// ErrNoText is returned when a message has no text/plain or text/html body.
+var ErrNoText = errors.New("message has no text body")
+
+
+// MailItemFromBytes parses a raw message, e.g. the content of a .eml file,
+// into a MailItem with Size set to len(b) and no message number. It allows
+// using the MailItem helpers on messages that do not come from a server.
+func MailItemFromBytes(b []byte) (item MailItem, err error) {
+    item.Email, err = parsemail.Parse(bytes.NewReader(b))
+    if err != nil {
+        return
+    }
+    item.Size = len(b)
+    return
+}
+
+
+// BestText returns the readable text of the message: the text/plain body if
+// there is one, otherwise the raw text/html body with isHTML set. The bodies
+// are found by parsemail, which walks multipart/mixed, multipart/alternative
+// and multipart/related structures and decodes the transfer encoding.
+//
+// Items built by GetList only hold the header, so BestText returns ErrNoText
+// for them; the message must have been fetched in full.
+func (m MailItem) BestText() (text string, isHTML bool, err error) {
+    if m.TextBody != "" {
+        return m.TextBody, false, nil
+    }
+    if m.HTMLBody != "" {
+        return m.HTMLBody, true, nil
+    }
+    return "", false, ErrNoText
+}
+
+
+// SanitizedHeaders returns the header fields of the message with values that
+// are guaranteed to be valid UTF-8, e.g. for JSON encoding. Encoded words
+// (RFC 2047) left undecoded are decoded first; bytes that are still not
+// valid UTF-8 are taken as ISO-8859-1, which is what legacy senders of raw
+// 8-bit headers mostly use. Repeated fields are joined with newlines. Keys
+// are in canonical form, e.g. "Message-Id".
+func (m MailItem) SanitizedHeaders() map[string]string {
+    dec := mime.WordDecoder{}
+    headers := make(map[string]string, len(m.Header))
+    for name, values := range m.Header {
+        clean := make([]string, len(values))
+        for i, v := range values {
+            if d, err := dec.DecodeHeader(v); err == nil {
+                v = d
+            }
+            clean[i] = toValidUTF8(v)
+        }
+        headers[name] = strings.Join(clean, "\n")
+    }
+    return headers
+}
+
+
+// toValidUTF8 keeps the valid UTF-8 sequences of s and converts every other
+// byte as if it were ISO-8859-1.
+func toValidUTF8(s string) string {
+    if utf8.ValidString(s) {
+        return s
+    }
+    var b strings.Builder
+    for len(s) > 0 {
+        r, size := utf8.DecodeRuneInString(s)
+        if r == utf8.RuneError && size == 1 {
+            r = rune(s[0])
+        }
+        b.WriteRune(r)
+        s = s[size:]
+    }
+    return b.String()
+}
+
+
+// HeaderUnfolded returns the value of the first header field with the given
+// name (case-insensitive), unfolded as RFC 5322 section 2.2.3 describes:
+// the line breaks before continuation lines are removed, the whitespace
+// which starts them is kept. For items with Raw (see GetMailWithRaw) the
+// field is read from the original bytes; otherwise Header is used, whose
+// parser has unfolded the value already, reducing each fold to one space.
+// The folded form remains available in Raw, or from Client.RawHeaders.
+func (m MailItem) HeaderUnfolded(name string) string {
+    if len(m.Raw) == 0 {
+        return m.Header.Get(name)
+    }
+
+    header := string(m.Raw)
+    if i := strings.Index(header, "\n\r\n"); i >= 0 {
+        header = header[:i+1]
+    }
+    if i := strings.Index(header, "\n\n"); i >= 0 {
+        header = header[:i+1]
+    }
+
+    var value strings.Builder
+    found := false
+    for _, line := range strings.SplitAfter(header, "\n") {
+        line = strings.TrimRight(line, "\r\n")
+        if found {
+            if line == "" || line[0] != ' ' && line[0] != '\t' {
+                break
+            }
+            value.WriteString(line)
+            continue
+        }
+        if i := strings.IndexByte(line, ':'); i > 0 && strings.EqualFold(strings.TrimRight(line[:i], " \t"), name) {
+            found = true
+            value.WriteString(line[i+1:])
+        }
+    }
+    return strings.TrimSpace(value.String())
+}
+
+
+// ReturnPath returns the address of the Return-Path header, without angle
+// brackets. It is empty if the header is missing or holds the null sender
+// "<>" used by bounces.
+func (m MailItem) ReturnPath() string {
+    return strings.Trim(strings.TrimSpace(m.Header.Get("Return-Path")), "<>")
+}
+
+
+// DeliveredTo returns the addresses of all Delivered-To headers, topmost
+// (the last delivery) first.
+func (m MailItem) DeliveredTo() []string {
+    var addrs []string
+    for _, v := range m.Header["Delivered-To"] {
+        if v = strings.Trim(strings.TrimSpace(v), "<>"); v != "" {
+            addrs = append(addrs, v)
+        }
+    }
+    return addrs
+}
+
+
+// Local parts of addresses that typically send bounces.
+var bounceSenders = []string{"mailer-daemon", "postmaster", "mail-daemon", "mailerdaemon"}
+
+
+// IsBounce guesses whether the message is a bounce or another delivery
+// status notification: it has the null sender as Return-Path, is a
+// multipart/report, or comes from an address like MAILER-DAEMON.
+func (m MailItem) IsBounce() bool {
+    if rp, ok := m.Header["Return-Path"]; ok && len(rp) > 0 && strings.TrimSpace(rp[0]) == "<>" {
+        return true
+    }
+
+    if t, _, err := mime.ParseMediaType(m.Header.Get("Content-Type")); err == nil && t == "multipart/report" {
+        return true
+    }
+
+    for _, from := range m.From {
+        local := strings.ToLower(from.Address)
+        if i := strings.LastIndexByte(local, '@'); i >= 0 {
+            local = local[:i]
+        }
+        for _, s := range bounceSenders {
+            if local == s {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+
+// ErrNoUnsubscribe is returned by Unsubscribe for messages without a
+// List-Unsubscribe header.
+var ErrNoUnsubscribe = errors.New("message has no List-Unsubscribe header")
+
+// UnsubscribeInfo holds the ways to unsubscribe from a mailing list offered
+// by a message (RFC 2369, RFC 8058).
+type UnsubscribeInfo struct {
+    MailTo   []string // mailto: URIs, e.g. "mailto:leave@example.com?subject=unsubscribe"
+    HTTPS    []string // https: URIs
+    OneClick bool     // a POST to the HTTPS URIs unsubscribes without further interaction
+}
+
+
+// Unsubscribe parses the List-Unsubscribe and List-Unsubscribe-Post headers
+// of the message. The URIs are returned as found, without angle brackets;
+// other schemes, including plain http, are ignored. OneClick is only set if
+// there is an HTTPS URI, as RFC 8058 requires. It works on items built by
+// GetList, which only hold the header.
+func (m MailItem) Unsubscribe() (*UnsubscribeInfo, error) {
+    values, ok := m.Header["List-Unsubscribe"]
+    if !ok {
+        return nil, ErrNoUnsubscribe
+    }
+
+    info := &UnsubscribeInfo{}
+    for _, v := range values {
+        for {
+            start := strings.IndexByte(v, '<')
+            if start < 0 {
+                break
+            }
+            end := strings.IndexByte(v[start:], '>')
+            if end < 0 {
+                break
+            }
+            uri := strings.TrimSpace(v[start+1 : start+end])
+            v = v[start+end+1:]
+
+            switch scheme := strings.ToLower(uri[:strings.IndexByte(uri+":", ':')]); scheme {
+            case "mailto":
+                info.MailTo = append(info.MailTo, uri)
+            case "https":
+                info.HTTPS = append(info.HTTPS, uri)
+            }
+        }
+    }
+
+    post := strings.TrimSpace(m.Header.Get("List-Unsubscribe-Post"))
+    info.OneClick = len(info.HTTPS) > 0 && strings.EqualFold(post, "List-Unsubscribe=One-Click")
+    return info, nil
+}
+
+
+// DKIMSignature holds the main tags of a DKIM-Signature header field (RFC
+// 6376). The signature is not verified.
+type DKIMSignature struct {
+    Domain        string   // signing domain (d=)
+    Selector      string   // key selector (s=)
+    Algorithm     string   // e.g. "rsa-sha256" (a=)
+    SignedHeaders []string // names of the signed header fields (h=)
+}
+
+
+// DKIMSignatures parses the DKIM-Signature header fields of the message, in
+// the order they appear. It returns nil if the message is not signed. Only
+// the header is needed, so it works on items built by GetList.
+func (m MailItem) DKIMSignatures() []DKIMSignature {
+    var sigs []DKIMSignature
+    for _, v := range m.Header["Dkim-Signature"] {
+        var sig DKIMSignature
+        for _, tag := range strings.Split(v, ";") {
+            i := strings.IndexByte(tag, '=')
+            if i < 0 {
+                continue
+            }
+            // folding white space may appear anywhere in tag values
+            value := strings.Join(strings.Fields(tag[i+1:]), "")
+            switch strings.TrimSpace(tag[:i]) {
+            case "d":
+                sig.Domain = value
+            case "s":
+                sig.Selector = value
+            case "a":
+                sig.Algorithm = value
+            case "h":
+                for _, name := range strings.Split(value, ":") {
+                    if name != "" {
+                        sig.SignedHeaders = append(sig.SignedHeaders, name)
+                    }
+                }
+            }
+        }
+        sigs = append(sigs, sig)
+    }
+    return sigs
+}
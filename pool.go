@@ -0,0 +1,170 @@
+package pop3
+
+import (
+    "context"
+    "errors"
+    "sync"
+)
+
+// ErrPoolClosed is returned by HostPool.Get after Close.
+var ErrPoolClosed = errors.New("pool is closed")
+
+// HostPool keeps idle clients for reuse and limits the number of connections
+// open to each host at the same time, since many providers only allow a few
+// simultaneous POP3 sessions per account or address.
+type HostPool struct {
+    dial       func(host string) (*Client, error)
+    perHostMax int
+
+    mu     sync.Mutex
+    hosts  map[string]*hostSlot
+    closed bool
+}
+
+type hostSlot struct {
+    open chan struct{} // holds one token per open connection
+    idle chan *Client
+}
+
+
+// NewPoolPerHost returns a pool which opens connections with dial, keeping at
+// most perHostMax connections (idle or in use) per host. The dial function
+// should return an authenticated client. If perHostMax <= 0, 1 is used.
+func NewPoolPerHost(dial func(host string) (*Client, error), perHostMax int) *HostPool {
+    if perHostMax <= 0 {
+        perHostMax = 1
+    }
+    return &HostPool{
+        dial:       dial,
+        perHostMax: perHostMax,
+        hosts:      make(map[string]*hostSlot),
+    }
+}
+
+
+func (p *HostPool) slot(host string) *hostSlot {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    s, ok := p.hosts[host]
+    if !ok {
+        s = &hostSlot{
+            open: make(chan struct{}, p.perHostMax),
+            idle: make(chan *Client, p.perHostMax),
+        }
+        p.hosts[host] = s
+    }
+    return s
+}
+
+
+// Get returns an idle client for host, or dials a new one. If the host is at
+// its connection limit, Get blocks until a client is returned with Put.
+// After Close, Get returns ErrPoolClosed.
+func (p *HostPool) Get(host string) (*Client, error) {
+    return p.GetContext(context.Background(), host)
+}
+
+
+// GetContext is like Get, but gives up waiting when ctx is done.
+func (p *HostPool) GetContext(ctx context.Context, host string) (*Client, error) {
+    s := p.slot(host)
+    for {
+        if p.isClosed() {
+            return nil, ErrPoolClosed
+        }
+        var c *Client
+        select {
+        case c = <-s.idle:
+        default:
+            select {
+            case c = <-s.idle:
+            case s.open <- struct{}{}:
+                if p.isClosed() {
+                    s.release()
+                    return nil, ErrPoolClosed
+                }
+                c, err := p.dial(host)
+                if err != nil {
+                    s.release()
+                    return nil, err
+                }
+                return c, nil
+            case <-ctx.Done():
+                return nil, ctx.Err()
+            }
+        }
+
+        if c.IsConnected() {
+            return c, nil
+        }
+        // the connection went away while idle
+        s.release()
+    }
+}
+
+
+// Put returns a client obtained from Get for host to the pool. Clients that
+// have been closed (e.g. by QUIT) only free their slot, as does nil. After
+// Close, the session of the client is ended.
+func (p *HostPool) Put(host string, c *Client) {
+    s := p.slot(host)
+    if c == nil || c.State() == StateClosed {
+        s.release()
+        return
+    }
+    if p.isClosed() {
+        c.QUIT()
+        s.release()
+        return
+    }
+    select {
+    case s.idle <- c:
+    default:
+        // more clients returned than were taken
+        c.QUIT()
+        s.release()
+    }
+}
+
+
+func (p *HostPool) isClosed() bool {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return p.closed
+}
+
+
+// Close ends the sessions of all idle clients. Clients in use are not
+// affected; they are closed when they are returned with Put.
+func (p *HostPool) Close() {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.closed = true
+    for _, s := range p.hosts {
+        s.drain()
+    }
+}
+
+
+// drain closes all idle clients of the slot.
+func (s *hostSlot) drain() {
+    for {
+        select {
+        case c := <-s.idle:
+            c.QUIT()
+            s.release()
+        default:
+            return
+        }
+    }
+}
+
+
+// release frees the slot of a connection. It does not block if no slot is
+// taken, e.g. when Put is called for a client that did not come from Get.
+func (s *hostSlot) release() {
+    select {
+    case <-s.open:
+    default:
+    }
+}
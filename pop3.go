@@ -2,69 +2,467 @@
 // 3 as defined in RFC 1939. Commands specified as optional are not
 // implemented; however, this implementation may be trivially extended to
 // support them.
+//
+// Clients give up on a server which sends nothing for DefaultTimeout (five
+// minutes); see SetTimeout to change this or to wait without limit.
 
 package pop3
 
 import (
     "bufio"
+    "bytes"
+    "context"
     "crypto/tls"
     "errors"
     "fmt"
+    "io"
+    "log/slog"
     "net"
     "strconv"
     "strings"
+    "time"
 )
 
 // The POP3 client.
 type Client struct {
-    conn net.Conn
-    bin  *bufio.Reader
+    conn  net.Conn
+    bin   *bufio.Reader
+    state State
+
+    greeting    string          // text of the server greeting, after "+OK "
+    lastCode    string          // response code of the last status line
+    caps        *Capabilities   // cached result of the last CAPA
+    active      *dotReader      // multiline response being streamed, if any
+    uids        map[string]int  // message numbers by unique id, for this session
+    warmedUp    bool            // the STAT of SetWarmupStat has been sent
+    noUIDL      bool            // the server rejected UIDL in this session
+    stats       Stats
+    idle        *idleTimer      // closes the connection when unused, see SetIdleTimeout
+    prefetching *MessageIter    // iterator whose prefetcher uses the connection
+    shutDown    bool            // Shutdown has been called
+    deleted     int             // messages marked with DELE in this session
+    msgMax      int             // highest message number, -1 if not known yet
+    pinned      bool            // a read deadline overrides the timeout
+    ctx         context.Context // context of the call in progress, see withContext
+    queue       []string        // commands waiting for the response in flight, see QueueCommand
+    holdQueue   bool            // pipelined responses are outstanding, the queue must wait
+
+    pipelineBatch int           // commands per pipelined batch, 0 means default
+    lineEnding    string        // command terminator, "" means CRLF
+    readOnly      bool          // refuse to send DELE
+    purgeUndated  bool          // PurgeOlderThan deletes messages without a date
+    warmupStat    bool          // send STAT before the first transaction command
+    verifySize    bool          // check streamed messages against LIST
+    strict        bool          // check that the server follows the protocol
+    verifyHeaders bool          // fetch headers cut off by TOP again with RETR
+    maxLine       int           // longest response line accepted, 0 means default
+    timeout       time.Duration // read timeout, 0 means DefaultTimeout, < 0 none
+    logger        *slog.Logger  // receives structured logs, see SetLogger
+    name          string        // identifies the client in logs, see SetName
+    limiter       *tokenBucket  // download rate limit, see Coordinator
+
+    dial    func() (net.Conn, error)          // used by Reconnect, nil for NewClient
+    tlsAddr string                            // server address for ReconnectWith, "" if not dialed with TLS
+    stls    *tls.Config                       // config for STLS after each greeting, nil if StartTLS is not used
+    reauth  func(*Client) error               // authenticates again after Reconnect
+    clock   func() time.Time                  // replaces time.Now in tests, see setClock
+    after   func(time.Duration, func()) timer // replaces time.AfterFunc in tests, see setClock
+}
+
+// State is the protocol state the client believes the session is in.
+type State int
+
+const (
+    StateGreeting      State = iota // waiting for the server greeting
+    StateAuthorization              // greeting read, not yet authenticated
+    StateTransaction                // authenticated, the maildrop is available
+    StateClosed                     // QUIT has been sent, the connection is closed
+)
+
+func (s State) String() string {
+    switch s {
+    case StateGreeting:
+        return "GREETING"
+    case StateAuthorization:
+        return "AUTHORIZATION"
+    case StateTransaction:
+        return "TRANSACTION"
+    case StateClosed:
+        return "CLOSED"
+    }
+    return "State(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Error is a negative (-ERR) response from the server.
+type Error struct {
+    Code string // response code without brackets (RFC 2449), e.g. "AUTH" or "SYS/TEMP"
+    Text string // the response text after "-ERR "
+}
+
+// newError builds an Error from the text of a -ERR response, extracting the
+// response code if there is one.
+func newError(text string) *Error {
+    return &Error{Code: responseCode(text), Text: text}
+}
+
+// responseCode returns the content of the bracketed response code at the
+// start of text, or "" if there is none.
+func responseCode(text string) string {
+    if !strings.HasPrefix(text, "[") {
+        return ""
+    }
+    end := strings.Index(text, "]")
+    if end < 0 {
+        return ""
+    }
+    return text[1:end]
+}
+
+func (e *Error) Error() string {
+    return e.Text
 }
 
+// ErrUnexpectedEOF is returned when the connection ends in the middle of a
+// multiline response. It wraps io.ErrUnexpectedEOF.
+var ErrUnexpectedEOF = fmt.Errorf("connection closed before end of response: %w", io.ErrUnexpectedEOF)
+
+// ErrReadOnly is returned instead of sending DELE when the client is in
+// read-only mode.
+var ErrReadOnly = errors.New("client is read-only, DELE refused")
+
+// ErrProtocolViolation is returned in strict mode when the server does not
+// follow the protocol, see SetStrict.
+var ErrProtocolViolation = errors.New("protocol violation by server")
+
+// ErrWrongState is returned when a command is issued in a state that does not
+// allow it, e.g. RETR before authentication.
+var ErrWrongState = errors.New("command not allowed in current state")
+
+// ErrInvalidMessageNumber is returned without sending anything when a
+// message number is not positive, or larger than the number of messages in
+// the maildrop once STAT or LIST have told it.
+var ErrInvalidMessageNumber = errors.New("invalid message number")
+
+// ErrLineTooLong is returned when a response line is longer than the limit
+// set with SetMaxLineLength. The line is discarded, so the connection stays
+// usable.
+var ErrLineTooLong = errors.New("response line too long")
+
+// ErrMalformedLine is wrapped by the error returned for a line of a LIST or
+// UIDL listing which cannot be parsed; the error quotes the line.
+var ErrMalformedLine = errors.New("malformed line in listing")
+
+// Longest response line accepted unless SetMaxLineLength says otherwise.
+const defaultMaxLineLength = 1 << 20
+
 // Dial creates an unsecured connection to the POP3 server at the given address
 // and returns the corresponding Client.
 func Dial(addr string) (*Client, error) {
-    conn, err := net.Dial("tcp", addr)
-    if err != nil {
-        return nil, err
-    }
-    return NewClient(conn)
+    return dialClient(func() (net.Conn, error) {
+        return net.Dial("tcp", addr)
+    })
 }
 
 // DialTLS creates a TLS-secured connection to the POP3 server at the given
 // address and returns the corresponding Client.
 func DialTLS(addr string) (*Client, error) {
-    conn, err := tls.Dial("tcp", addr, nil)
+    return DialTLSWithConfig(addr, nil)
+}
+
+// dialClient creates a Client over a connection returned by dial. The dial
+// function is kept so the client can reconnect later.
+func dialClient(dial func() (net.Conn, error)) (*Client, error) {
+    conn, err := dial()
     if err != nil {
         return nil, err
     }
-    return NewClient(conn)
+    client, err := NewClient(conn)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    client.dial = dial
+    return client, nil
 }
 
 // NewClient returns a new Client object using an existing connection.
 func NewClient(conn net.Conn) (*Client, error) {
-    client := &Client{
-        bin:  bufio.NewReader(conn),
-        conn: conn,
-    }
-    // send dud command, to read a line
-    _, err := client.Cmd("")
+    client := &Client{}
+    err := client.greet(conn)
     if err != nil {
         return nil, err
     }
     return client, nil
 }
 
+// greet starts a new session over conn and reads the server greeting.
+func (c *Client) greet(conn net.Conn) error {
+    c.conn = conn
+    c.bin = bufio.NewReader(meter{c})
+    c.state = StateGreeting
+    c.caps = nil
+    c.active = nil
+    c.queue = nil
+    c.holdQueue = false
+    c.uids = nil
+    c.noUIDL = false
+    c.deleted = 0
+    c.msgMax = -1
+    if c.idle != nil {
+        c.idle.start(conn)
+    }
+    // send dud command, to read a line
+    greeting, err := c.Cmd("")
+    if err != nil {
+        return err
+    }
+    c.greeting = greeting
+    c.state = StateAuthorization
+    return nil
+}
+
+// State returns the protocol state the client believes it is in. While a
+// prefetcher shares the connection (see MessagesPrefetch), the session is
+// its own, and State reports the state from before, updated when the
+// iterator is closed.
+func (c *Client) State() State {
+    if c.idleExpired() {
+        c.state = StateClosed
+    }
+    return c.state
+}
+
+// Greeting returns the text of the server greeting, without the leading
+// "+OK ".
+func (c *Client) Greeting() string {
+    return c.greeting
+}
+
+// SetReadOnly turns read-only mode on or off. In read-only mode, DELE (also
+// when sent through Cmd) fails with ErrReadOnly without touching the network,
+// so that inspection tools cannot delete mail by accident.
+func (c *Client) SetReadOnly(ro bool) {
+    c.readOnly = ro
+}
+
+// IsReadOnly reports whether the client is in read-only mode.
+func (c *Client) IsReadOnly() bool {
+    return c.readOnly
+}
+
+// requireState returns ErrWrongState if the client is not in the given state.
+func (c *Client) requireState(want State) error {
+    if c.state != want {
+        return fmt.Errorf("%w: in %s state, need %s", ErrWrongState, c.state, want)
+    }
+    return nil
+}
+
+// checkMsg returns ErrInvalidMessageNumber if msg cannot be a message of the
+// maildrop. Messages marked as deleted keep their numbers but are not
+// counted by STAT and LIST, so they are added back.
+func (c *Client) checkMsg(msg int) error {
+    if msg <= 0 || c.msgMax >= 0 && msg > c.msgMax {
+        return fmt.Errorf("%w: %d", ErrInvalidMessageNumber, msg)
+    }
+    return nil
+}
+
 // Convenience function to synchronously run an arbitrary command and wait for
 // output. The terminating CRLF must be included in the format string.
 //
 // Output sent after the first line must be retrieved via readLines.
 func (c *Client) Cmd(format string, args ...interface{}) (string, error) {
-    fmt.Fprintf(c.conn, format, args...)
-    line, _, err := c.bin.ReadLine()
+    cmd := c.command(format, args...)
+    if c.readOnly && verb(cmd) == "DELE" {
+        return "", ErrReadOnly
+    }
+    if err := c.checkShared(); err != nil {
+        return "", err
+    }
+    if err := c.warmup(); err != nil {
+        return "", err
+    }
+    start, read := c.now(), c.stats.BytesRead
+    if cmd != "" {
+        if err := c.write(cmd); err != nil {
+            c.logCmd(cmd, "", err, start, 0)
+            return "", err
+        }
+    }
+    status, err := c.response(cmd)
+    c.logCmd(cmd, status, err, start, c.stats.BytesRead-read)
+    if err == nil {
+        switch verb(cmd) {
+        case "DELE":
+            c.deleted++
+        case "RSET":
+            c.deleted = 0
+        }
+    }
+    return status, err
+}
+
+// write sends one or more command lines. In strict mode, it first checks
+// that the server has not sent anything that was not asked for.
+func (c *Client) write(cmds string) error {
+    if c.conn == nil {
+        return ErrNotConnected
+    }
+    if err := c.checkShared(); err != nil {
+        return err
+    }
+    if c.idle != nil {
+        // the timer must not send QUIT in the middle of a command
+        c.idle.mu.Lock()
+        if c.idle.expired {
+            c.idle.mu.Unlock()
+            c.state = StateClosed
+            return ErrIdleTimeout
+        }
+        c.idle.last = c.idle.now()
+        c.idle.busy++
+        c.idle.mu.Unlock()
+        defer c.idleUse(-1)
+    }
+    if c.strict && c.bin.Buffered() > 0 {
+        return fmt.Errorf("%w: %d unexpected bytes before %q", ErrProtocolViolation, c.bin.Buffered(), verb(cmds))
+    }
+    _, err := io.WriteString(c.conn, cmds)
+    return err
+}
+
+// SetStrict turns strict mode on or off. In strict mode the client checks
+// that the server follows RFC 1939 and RFC 2449 to the letter and returns
+// ErrProtocolViolation when it does not: status lines must start with "+OK"
+// or "-ERR" followed by a space or the end of the line, no data may arrive
+// that was not asked for, and a message sent by RETR must not be larger
+// than the octet count announced in its status line, and after StartTLS the
+// server must announce a way to log in. Strict mode is meant for
+// interoperability testing and is off by default.
+func (c *Client) SetStrict(on bool) {
+    c.strict = on
+}
+
+// CmdOK works like Cmd, except that rest is empty on a negative response,
+// where Cmd also returns the rest of the status line; the text is then only
+// in the *Error.
+func (c *Client) CmdOK(format string, args ...interface{}) (rest string, err error) {
+    if rest, err = c.Cmd(format, args...); err != nil {
+        return "", err
+    }
+    return
+}
+
+// CmdMulti runs an arbitrary command whose positive response is multiline,
+// such as an extension unknown to this package, and returns the status text
+// and the lines of the response (dot-stuffing removed). The caller must know
+// that the command produces a multiline response: otherwise CmdMulti waits
+// for a terminating line that never comes. On a negative response, no lines
+// are read and err is the *Error.
+func (c *Client) CmdMulti(format string, args ...interface{}) (status string, lines []string, err error) {
+    status, err = c.Cmd(format, args...)
+    if err != nil {
+        return "", nil, err
+    }
+    lines, err = c.ReadLines()
+    return
+}
+
+// command formats a command line, replacing the CRLF terminator by the line
+// ending set with SetLineEnding.
+func (c *Client) command(format string, args ...interface{}) string {
+    cmd := fmt.Sprintf(format, args...)
+    if c.lineEnding != "" && strings.HasSuffix(cmd, "\r\n") {
+        cmd = cmd[:len(cmd)-2] + c.lineEnding
+    }
+    return cmd
+}
+
+// SetLineEnding sets the terminator sent after each command instead of the
+// CRLF required by RFC 1939. This is only meant for broken servers which do
+// not accept CRLF; compliant servers may reject anything else. An empty s
+// restores the default.
+func (c *Client) SetLineEnding(s string) {
+    c.lineEnding = s
+    if c.idle != nil {
+        c.idle.mu.Lock()
+        c.idle.quit = c.command("QUIT\r\n")
+        c.idle.mu.Unlock()
+    }
+}
+
+// SetMaxLineLength sets the longest line, in bytes, that is accepted in a
+// response read line by line (status lines, ReadLines, RetrLines). Longer
+// lines are read to their end without being kept, and ErrLineTooLong is
+// returned, which protects against servers sending endless lines. The
+// default is 1 MiB; n <= 0 restores it. Readers returned by RetrReader
+// stream long lines in pieces and are not limited.
+func (c *Client) SetMaxLineLength(n int) {
+    c.maxLine = n
+}
+
+// readLine reads a response line without its line ending.
+func (c *Client) readLine() ([]byte, error) {
+    max := c.maxLine
+    if max <= 0 {
+        max = defaultMaxLineLength
+    }
+    return readLine(c.bin, max)
+}
+
+// readLine reads a line from br like bufio.Reader.ReadLine, joining the
+// pieces of lines longer than the buffer. Only LF ends a line; a CR before it
+// is removed, any other CR is kept as part of the line. A line longer than
+// max bytes is read to its end and discarded, and ErrLineTooLong is
+// returned. A last line cut off by the end of the input is returned along
+// with io.ErrUnexpectedEOF.
+func readLine(br *bufio.Reader, max int) (line []byte, err error) {
+    tooLong := false
+    for {
+        frag, err := br.ReadSlice('\n')
+        if err != nil && err != bufio.ErrBufferFull {
+            if err == io.EOF && (len(line) > 0 || len(frag) > 0) && !tooLong {
+                return append(line, frag...), io.ErrUnexpectedEOF
+            }
+            return nil, err
+        }
+        // the CR of a CRLF may end a fragment, so the limit allows for it
+        if !tooLong && len(line)+len(frag) > max+2 {
+            tooLong = true
+            line = nil
+        }
+        if !tooLong {
+            line = append(line, frag...)
+        }
+        if err == nil {
+            break
+        }
+    }
+    if tooLong {
+        return nil, ErrLineTooLong
+    }
+    line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+    if len(line) > max {
+        return nil, ErrLineTooLong
+    }
+    return line, nil
+}
+
+// response reads and parses a single status line, sent in reply to cmd.
+func (c *Client) response(cmd string) (string, error) {
+    line, err := c.readLine()
+    // a status line is accepted without its line ending
+    if err == io.ErrUnexpectedEOF && len(line) > 0 {
+        err = nil
+    }
     if err != nil { return "", err }
     l := string(line)
 
+    if c.strict && !validStatus(l) {
+        return "", fmt.Errorf("%w: invalid status line %q", ErrProtocolViolation, l)
+    }
+
     if len(l) < 3 {
         return "", errors.New("response incorrect")
     }
@@ -73,28 +471,127 @@ func (c *Client) Cmd(format string, args ...interface{}) (string, error) {
         if len(l) < 5 {
             return "", errors.New("response incorrect")
         }
-        err = errors.New(l[5:])
+        e := newError(l[5:])
+        c.lastCode = e.Code
+        err = e
     }
 
+    if err == nil {
+        c.advance(cmd)
+    }
+
+    if err == nil {
+        c.lastCode = ""
+        if len(l) >= 4 {
+            c.lastCode = responseCode(l[4:])
+        }
+    }
     if len(l) >= 4 {
         return l[4:], err
     }
     return "", err
 }
 
+// LastResponseCode returns the response code (RFC 2449) of the last status
+// line read, without brackets, or "" if it had none. Unlike Error.Code, this
+// also covers +OK responses, on which some servers send advisory codes.
+func (c *Client) LastResponseCode() string {
+    return c.lastCode
+}
+
+// validStatus reports whether l is a well-formed status line.
+func validStatus(l string) bool {
+    for _, status := range []string{"+OK", "-ERR"} {
+        if l == status || strings.HasPrefix(l, status+" ") {
+            return true
+        }
+    }
+    return false
+}
+
+// verb returns the upper-cased command name of a command line.
+func verb(cmd string) string {
+    fs := strings.Fields(cmd)
+    if len(fs) == 0 {
+        return ""
+    }
+    return strings.ToUpper(fs[0])
+}
+
+// advance updates the session state after a successful command, so that
+// commands sent through Cmd directly are tracked as well.
+func (c *Client) advance(cmd string) {
+    switch verb(cmd) {
+    case "AUTH":
+        // without a mechanism, AUTH lists the mechanisms
+        if len(strings.Fields(cmd)) < 2 {
+            return
+        }
+        c.enterTransaction()
+    case "PASS", "APOP":
+        c.enterTransaction()
+    case "QUIT":
+        c.state = StateClosed
+    }
+}
+
+// enterTransaction records a successful login. Servers may announce other
+// capabilities after login (RFC 2449), so the cached CAPA is dropped.
+func (c *Client) enterTransaction() {
+    c.state = StateTransaction
+    c.warmedUp = false
+    c.caps = nil
+}
+
+// ReadLines reads a multiline response up to the terminating ".", removing
+// the dot-stuffing. If the connection ends before the terminator,
+// ErrUnexpectedEOF is returned along with the lines read so far, which must
+// not be taken for the complete response.
 func (c *Client) ReadLines() (lines []string, err error) {
     lines = make([]string, 0)
-    l, _, err := c.bin.ReadLine()
-    line := string(l)
-    for err == nil && line != "." {
+    err = c.eachLine(func(line string) error {
+        lines = append(lines, line)
+        return nil
+    })
+    return
+}
+
+// eachLine reads a multiline response like ReadLines, but passes each line to
+// fn instead of collecting them. If fn returns an error, or a line is too
+// long, the rest of the response is read and discarded, then the error is
+// returned.
+func (c *Client) eachLine(fn func(line string) error) (err error) {
+    var fnErr error
+    for {
+        l, e := c.readLine()
+        if e == ErrLineTooLong {
+            if fnErr == nil {
+                fnErr = e
+            }
+            continue
+        }
+        if err = e; err != nil {
+            break
+        }
+
+        line := string(l)
+        if line == "." {
+            break
+        }
         if len(line) > 0 && line[0] == '.' {
             line = line[1:]
         }
-        lines = append(lines, line)
-        l, _, err = c.bin.ReadLine()
-        line = string(l)
+        if fnErr == nil {
+            fnErr = fn(line)
+        }
     }
-    return
+    if err == io.EOF || err == io.ErrUnexpectedEOF {
+        err = ErrUnexpectedEOF
+    }
+    if err != nil {
+        return err
+    }
+    return fnErr
 }
 
 // USER sends the given username to the server. Generally, there is no reason
@@ -115,12 +612,21 @@ func (c *Client) PASS(password string) (err error) {
 
 // Auth sends the given username and password to the server, calling the User
 // and Pass methods as appropriate.
+//
+// On success the credentials are remembered, so that Reconnect can
+// authenticate the new session.
 func (c *Client) Auth(username, password string) (err error) {
     err = c.USER(username)
     if err != nil {
         return
     }
     err = c.PASS(password)
+    if err != nil {
+        return
+    }
+    c.reauth = func(c *Client) error {
+        return c.Auth(username, password)
+    }
     return
 }
 
@@ -130,6 +636,9 @@ func (c *Client) Auth(username, password string) (err error) {
 // maildrop is ignored. In the event of an error, all returned numeric values
 // will be 0.
 func (c *Client) STAT() (count, size int, err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return 0, 0, err
+    }
     l, err := c.Cmd("STAT\r\n")
     if err != nil {
         return 0, 0, err
@@ -143,6 +652,7 @@ func (c *Client) STAT() (count, size int, err error) {
     if err != nil {
         return 0, 0, errors.New("Invalid server response")
     }
+    c.msgMax = count + c.deleted
     return
 }
 
@@ -150,6 +660,12 @@ func (c *Client) STAT() (count, size int, err error) {
 // does not exist, or another error is encountered, the returned size will be
 // 0.
 func (c *Client) LIST(msg int) (size int, err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return 0, err
+    }
+    if err = c.checkMsg(msg); err != nil {
+        return 0, err
+    }
     l, err := c.Cmd("LIST %d\r\n", msg)
     if err != nil {
         return 0, err
@@ -162,30 +678,68 @@ func (c *Client) LIST(msg int) (size int, err error) {
 }
 
 // ListAll returns a list of all messages and their sizes.
+//
+// Like UidlAll, it returns the entries received so far along with the
+// error if the listing breaks off or has a malformed line.
 func (c *Client) ListAll() (msgs []int, sizes []int, err error) {
-    _, err = c.Cmd("LIST\r\n")
-    if err != nil {
+    if err = c.requireState(StateTransaction); err != nil {
         return
     }
-    lines, err := c.ReadLines()
+    _, err = c.Cmd("LIST\r\n")
     if err != nil {
         return
     }
-    msgs = make([]int, len(lines), len(lines))
-    sizes = make([]int, len(lines), len(lines))
-    for i, l := range lines {
+    lines, readErr := c.ReadLines()
+    msgs = make([]int, 0, len(lines))
+    sizes = make([]int, 0, len(lines))
+    for _, l := range lines {
         var m, s int
-        fs := strings.Fields(l)
-        m, err = strconv.Atoi(fs[0])
-        if err != nil {
+        if m, s, err = parseListLine(l); err != nil {
             return
         }
-        s, err = strconv.Atoi(fs[1])
+        msgs = append(msgs, m)
+        sizes = append(sizes, s)
+    }
+    if err = readErr; err == nil {
+        c.msgMax = len(msgs) + c.deleted
+    }
+    return
+}
+
+// ListEach works like ListAll, but parses the listing line by line and calls
+// fn for each message, so that the listing of a huge maildrop is never held
+// in memory. If fn returns an error, the rest of the listing is read and
+// discarded (keeping the connection usable) and the error is returned.
+func (c *Client) ListEach(fn func(msg, size int) error) (err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return
+    }
+    _, err = c.Cmd("LIST\r\n")
+    if err != nil {
+        return
+    }
+    return c.eachLine(func(l string) error {
+        m, s, err := parseListLine(l)
         if err != nil {
-            return
+            return err
         }
-        msgs[i] = m
-        sizes[i] = s
+        return fn(m, s)
+    })
+}
+
+// parseListLine parses a line of a LIST listing: a message number and its
+// size.
+func parseListLine(l string) (msg, size int, err error) {
+    fs := strings.Fields(l)
+    if len(fs) < 2 {
+        return 0, 0, fmt.Errorf("%w: %q", ErrMalformedLine, l)
+    }
+    msg, err = strconv.Atoi(fs[0])
+    if err == nil {
+        size, err = strconv.Atoi(fs[1])
+    }
+    if err != nil {
+        return 0, 0, fmt.Errorf("%w: %q", ErrMalformedLine, l)
     }
     return
 }
@@ -193,17 +747,37 @@ func (c *Client) ListAll() (msgs []int, sizes []int, err error) {
 // RETR downloads and returns the given message. The lines are separated by LF,
 // whatever the server sent.
 func (c *Client) RETR(msg int) (text string, err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return "", err
+    }
+    if err = c.checkMsg(msg); err != nil {
+        return "", err
+    }
     _, err = c.Cmd("RETR %d\r\n", msg)
     if err != nil {
         return "", err
     }
     lines, err := c.ReadLines()
+    if err != nil {
+        return "", err
+    }
     text = strings.Join(lines, "\n")
     return
 }
 
-// DELE marks the given message as deleted.
+// DELE marks the given message as deleted. It returns ErrReadOnly if the
+// client is in read-only mode, and ErrReaderOpen while a message is being
+// read; QueueCommand can delete a message after the end of that one.
 func (c *Client) DELE(msg int) (err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return
+    }
+    if err = c.checkMsg(msg); err != nil {
+        return
+    }
+    if c.active != nil {
+        return ErrReaderOpen
+    }
     _, err = c.Cmd("DELE %d\r\n", msg)
     return
 }
@@ -217,6 +791,9 @@ func (c *Client) NOOP() (err error) {
 
 // Rset unmarks any messages marked for deletion previously in this session.
 func (c *Client) Rset() (err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return
+    }
     _, err = c.Cmd("RSET\r\n")
     return
 }
@@ -227,6 +804,9 @@ func (c *Client) QUIT() error {
     if err != nil {
         return err
     }
+    if c.idle != nil {
+        c.idle.stop()
+    }
     c.conn.Close()
     return nil
 }
@@ -3,8 +3,14 @@
 package pop3
 
 import (
+    "bytes"
+    "crypto/sha256"
     "crypto/tls"
+    "crypto/x509"
+    "errors"
     "fmt"
+    "net"
+    "net/mail"
     "strconv"
     "strings"
 
@@ -24,13 +30,82 @@ func DialTLSSkipVerify(addr string) (*Client, error) {
 
 // DialTLSWithConfig creates a TLS-secured connection to the POP3 server. The
 // param tlsConfig can be used for more sophisticated control about TLS
-// transmission.
+// transmission. The same config is used when the client reconnects, so a
+// ClientSessionCache in it allows TLS session resumption.
 func DialTLSWithConfig(addr string, tlsConfig *tls.Config) (*Client, error) {
-    conn, err := tls.Dial("tcp", addr, tlsConfig)
+    c, err := dialClient(func() (net.Conn, error) {
+        return tls.Dial("tcp", addr, tlsConfig)
+    })
     if err != nil {
         return nil, err
     }
-    return NewClient(conn)
+    c.tlsAddr = addr
+    return c, nil
+}
+
+
+// DialTLSWithCache creates a TLS-secured connection to the POP3 server, using
+// cache to store TLS sessions. When the client reconnects, or when cache is
+// shared between clients, servers supporting it resume the session instead of
+// doing a full handshake.
+func DialTLSWithCache(addr string, cache tls.ClientSessionCache) (*Client, error) {
+    return DialTLSWithConfig(addr, &tls.Config{ClientSessionCache: cache})
+}
+
+
+// DialTLSClientCert creates a TLS-secured connection to a POP3 server which
+// requires client certificate authentication (mutual TLS), presenting cert.
+// If rootCAs is nil, the server certificate is verified against the system
+// roots.
+func DialTLSClientCert(addr string, cert tls.Certificate, rootCAs *x509.CertPool) (*Client, error) {
+    return DialTLSWithConfig(addr, &tls.Config{
+        Certificates: []tls.Certificate{cert},
+        RootCAs:      rootCAs,
+    })
+}
+
+
+// ErrPinMismatch is returned by DialTLSPinned when the public key of the
+// server certificate matches none of the pins.
+var ErrPinMismatch = errors.New("server certificate does not match any pin")
+
+
+// DialTLSPinned creates a TLS-secured connection to the POP3 server, trusting
+// the server only if the SHA-256 hash of the SubjectPublicKeyInfo of its
+// leaf certificate is one of pinnedSHA256. CA trust and the host name are not
+// checked, so the server may use a self-signed certificate. Keys can be
+// pinned this way across certificate renewals.
+func DialTLSPinned(addr string, pinnedSHA256 [][]byte) (*Client, error) {
+    return DialTLSWithConfig(addr, &tls.Config{
+        InsecureSkipVerify: true,
+        VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+            if len(rawCerts) == 0 {
+                return ErrPinMismatch
+            }
+            leaf, err := x509.ParseCertificate(rawCerts[0])
+            if err != nil {
+                return err
+            }
+            sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+            for _, pin := range pinnedSHA256 {
+                if bytes.Equal(pin, sum[:]) {
+                    return nil
+                }
+            }
+            return ErrPinMismatch
+        },
+    })
+}
+
+
+// ConnectionState returns the state of the TLS connection, e.g. whether the
+// session was resumed. The bool is false if the connection is not secured
+// by TLS.
+func (c *Client) ConnectionState() (tls.ConnectionState, bool) {
+    if tc, ok := c.conn.(*tls.Conn); ok {
+        return tc.ConnectionState(), true
+    }
+    return tls.ConnectionState{}, false
 }
 
 
@@ -38,6 +113,12 @@ func DialTLSWithConfig(addr string, tlsConfig *tls.Config) (*Client, error) {
 // does not exist, or another error is encountered, the returned unique id will
 // be "". Param msg means message number.
 func (c *Client) UIDL(msg int) (uid string, err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return
+    }
+    if err = c.checkMsg(msg); err != nil {
+        return
+    }
     l, err := c.Cmd("UIDL %d\r\n", msg)
     if err != nil {
         return
@@ -48,26 +129,98 @@ func (c *Client) UIDL(msg int) (uid string, err error) {
 
 
 // UidlAll returns a list of all message numbers and their unique ids.
+//
+// If the listing breaks off, e.g. because the connection drops in the middle
+// of a huge listing, the entries received so far are returned along with
+// the error, as are those before a malformed line. After a transport error
+// the connection is most likely unusable: Reconnect before going on.
 func (c *Client) UidlAll() (msgs []int, uids []string, err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return
+    }
     _, err = c.Cmd("UIDL\r\n")
     if err != nil {
         return
     }
-    lines, err := c.ReadLines()
+    lines, readErr := c.ReadLines()
+    msgs = make([]int, 0, len(lines))
+    uids = make([]string, 0, len(lines))
+    for _, l := range lines {
+        var m int
+        var uid string
+        if m, uid, err = parseUidlLine(l); err != nil {
+            return
+        }
+        msgs = append(msgs, m)
+        uids = append(uids, uid)
+    }
+    err = readErr
+    return
+}
+
+
+// UidlEach works like UidlAll, but parses the listing line by line and calls
+// fn for each message, so that the listing of a huge maildrop is never held
+// in memory. If fn returns an error, the rest of the listing is read and
+// discarded (keeping the connection usable) and the error is returned.
+func (c *Client) UidlEach(fn func(msg int, uid string) error) (err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return
+    }
+    _, err = c.Cmd("UIDL\r\n")
     if err != nil {
         return
     }
-    msgs = make([]int, len(lines), len(lines))
-    uids = make([]string, len(lines), len(lines))
-    for i, l := range lines {
-        var m int
-        fs := strings.Fields(l)
-        m, err = strconv.Atoi(fs[0])
+    return c.eachLine(func(l string) error {
+        m, uid, err := parseUidlLine(l)
         if err != nil {
-            return
+            return err
+        }
+        return fn(m, uid)
+    })
+}
+
+
+// parseUidlLine parses a line of a UIDL listing: a message number and its
+// unique id.
+func parseUidlLine(l string) (msg int, uid string, err error) {
+    fs := strings.Fields(l)
+    if len(fs) < 2 {
+        return 0, "", fmt.Errorf("%w: %q", ErrMalformedLine, l)
+    }
+    if msg, err = strconv.Atoi(fs[0]); err != nil {
+        return 0, "", fmt.Errorf("%w: %q", ErrMalformedLine, l)
+    }
+    return msg, fs[1], nil
+}
+
+
+// UidlAllWithDuplicates works like UidlAll, but also checks the unique ids
+// for duplicates, which some broken servers return. Each id used by more than
+// one message is mapped to the numbers of those messages; duplicates is nil
+// if all ids are unique.
+func (c *Client) UidlAllWithDuplicates() (msgs []int, uids []string, duplicates map[string][]int, err error) {
+    msgs, uids, err = c.UidlAll()
+    if err != nil {
+        return
+    }
+    duplicates = findDuplicateUIDs(msgs, uids)
+    return
+}
+
+
+func findDuplicateUIDs(msgs []int, uids []string) (duplicates map[string][]int) {
+    seen := make(map[string][]int, len(uids))
+    for i, uid := range uids {
+        seen[uid] = append(seen[uid], msgs[i])
+    }
+    for uid, ms := range seen {
+        if len(ms) > 1 {
+            if duplicates == nil {
+                duplicates = make(map[string][]int)
+            }
+            duplicates[uid] = ms
         }
-        msgs[i] = m
-        uids[i] = fs[1]
     }
     return
 }
@@ -75,6 +228,12 @@ func (c *Client) UidlAll() (msgs []int, uids []string, err error) {
 
 // TOP returns first n rows of a message.
 func (c *Client) TOP(msg, n int) (text string, err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return
+    }
+    if err = c.checkMsg(msg); err != nil {
+        return
+    }
     _, err = c.Cmd("TOP %d %d\r\n", msg, n)
     if err != nil {
         return
@@ -88,6 +247,47 @@ func (c *Client) TOP(msg, n int) (text string, err error) {
 }
 
 
+// ErrNoMessageID is returned by MessageID for messages without a Message-ID
+// header.
+var ErrNoMessageID = errors.New("message has no Message-ID header")
+
+
+// MessageID returns the Message-ID of a message, without angle brackets. It
+// only fetches the header (TOP msg 0) and scans it for the field, without
+// parsing the rest.
+func (c *Client) MessageID(msg int) (id string, err error) {
+    text, err := c.TOP(msg, 0)
+    if err != nil {
+        return
+    }
+
+    found := false
+    for _, l := range strings.Split(text, "\n") {
+        if l == "" {
+            break
+        }
+        if found {
+            // folded continuation of the field
+            if l[0] != ' ' && l[0] != '\t' {
+                break
+            }
+            id += " " + strings.TrimSpace(l)
+            continue
+        }
+        if i := strings.IndexByte(l, ':'); i > 0 && strings.EqualFold(strings.TrimSpace(l[:i]), "Message-ID") {
+            found = true
+            id = strings.TrimSpace(l[i+1:])
+        }
+    }
+
+    id = strings.Trim(strings.TrimSpace(id), "<>")
+    if id == "" {
+        return "", ErrNoMessageID
+    }
+    return
+}
+
+
 // GetMail get a mail by message number.
 func (c *Client) GetMail(msg int) (email parsemail.Email, err error) {
     text, err := c.RETR(msg)
@@ -100,21 +300,77 @@ func (c *Client) GetMail(msg int) (email parsemail.Email, err error) {
 }
 
 
+// GetMailWithRaw gets a mail by message number like GetMail, and also keeps
+// the original bytes of the message in Raw, e.g. to store or forward a
+// faithful copy. The message is held in memory twice, as bytes and parsed.
+// If parsemail cannot parse the body (e.g. a multipart/report), only the
+// header is parsed and Complete is false, since Raw still has it all.
+func (c *Client) GetMailWithRaw(msg int) (item MailItem, err error) {
+    var b bytes.Buffer
+    if _, err = c.RetrTo(msg, &b); err != nil {
+        return
+    }
+    item.MsgNum = msg
+    item.Size = b.Len()
+    item.Raw = b.Bytes()
+
+    if item.Email, err = parsemail.Parse(bytes.NewReader(item.Raw)); err == nil {
+        item.Complete = true
+        return
+    }
+    item.Email, item.ParseWarning = parseHeader(string(item.Raw))
+    err = nil
+    return
+}
+
+
+// GetNetMail gets a mail by message number and parses it with net/mail, which
+// only splits the header from the body and does no MIME decoding.
+func (c *Client) GetNetMail(msg int) (*mail.Message, error) {
+    text, err := c.RETR(msg)
+    if err != nil {
+        return nil, err
+    }
+
+    return mail.ReadMessage(strings.NewReader(text))
+}
+
+
 type MailItem struct {
     parsemail.Email
     Size    int
-    MsgNum  int // message number
+    MsgNum  int     // message number
+    UID     string  // unique id from UIDL, if known
+
+    // Email was parsed from the whole message, not only from the header, so
+    // the bodies and attachments are available.
+    Complete bool
+
+    // The message as sent by the server, with CRLF line endings and without
+    // dot-stuffing. Only set by GetMailWithRaw.
+    Raw []byte
+
+    // Why the header could only be parsed in part, or nil. Items with a
+    // malformed header are still returned, holding the fields that could be
+    // parsed, so that the message is not lost from a listing.
+    ParseWarning error
 }
 
 
+// Number of body lines GetInfo asks for along with the header.
+const infoLines = 120
+
+
 // Get basic mail info by message number. In the return value of email, not all fields are valid.
+// A malformed header does not fail GetInfo: the fields that could be parsed
+// are returned, see MailItem.ParseWarning.
 func (c *Client) GetInfo(msg int) (email parsemail.Email, err error) {
-    text, err := c.TOP(msg, 120)
+    text, _, err := c.topVerified(msg, infoLines)
     if err != nil {
         return
     }
 
-    email, err = parsemail.ParseHeader(strings.NewReader(text))
+    email, _ = parseHeader(text)
     return
 }
 
@@ -122,6 +378,185 @@ func (c *Client) GetInfo(msg int) (email parsemail.Email, err error) {
 // Get recent n's email item from the mailbox, if n <= 0, get all the email item.
 // The most recent email item is in the front of the list slice.
 func (c *Client) GetList(n int) (list []MailItem, err error) {
+    return c.GetListWithOptions(GetListOptions{N: n})
+}
+
+
+// GetListPipelined works like GetList, but if the server supports
+// PIPELINING, the TOP commands are sent in batches (see SetPipelineBatch)
+// instead of one round trip per message. Otherwise it falls back to sending
+// them one by one.
+func (c *Client) GetListPipelined(n int) (list []MailItem, err error) {
+    return c.GetListWithOptions(GetListOptions{N: n, Pipelined: true})
+}
+
+
+// GetListOptions controls GetListWithOptions. The zero value behaves like
+// GetList(0).
+type GetListOptions struct {
+    N int // get the n most recent messages, all if N <= 0
+
+    // Send the commands in batches if the server supports PIPELINING, as
+    // GetListPipelined does.
+    Pipelined bool
+
+    // Messages smaller than RetrBelow octets (as reported by LIST) are
+    // fetched in full with RETR instead of TOP, since that costs little more
+    // and saves a second download when the message is opened. Such items
+    // have Complete set. 0 means always use TOP.
+    RetrBelow int
+
+    // Also issue UIDL, set the UID of the items and keep the ids for
+    // RetrByUID and DeleByUID, which then need no UIDL of their own.
+    PopulateUIDCache bool
+
+    // If set, a negative response to the TOP or RETR of a listed message,
+    // as when another session deleted it after the listing, is not fatal:
+    // the message is left out of the list and OnVanished is called with an
+    // error wrapping ErrVanished as a warning. Otherwise the negative
+    // response fails GetListWithOptions.
+    OnVanished func(warning error)
+}
+
+
+// GetListWithOptions gets the most recent messages like GetList, as
+// controlled by opts.
+func (c *Client) GetListWithOptions(opts GetListOptions) (list []MailItem, err error) {
+    list, err = c.recentItems(opts.N)
+    if err != nil {
+        return
+    }
+
+    if opts.PopulateUIDCache {
+        if err = c.loadUIDs(); err != nil {
+            return
+        }
+        uidOf := make(map[int]string, len(c.uids))
+        for uid, m := range c.uids {
+            uidOf[m] = uid
+        }
+        for i := range list {
+            list[i].UID = uidOf[list[i].MsgNum]
+        }
+    }
+
+    cmds := make([]string, len(list))
+    for i, item := range list {
+        if item.Size < opts.RetrBelow {
+            cmds[i] = c.command("RETR %d\r\n", item.MsgNum)
+        } else {
+            cmds[i] = c.command("TOP %d %d\r\n", item.MsgNum, infoLines)
+        }
+    }
+
+    batch := 1
+    if opts.Pipelined {
+        if batch, err = c.batchSize(); err != nil {
+            return
+        }
+    }
+
+    gone := make(map[int]bool)
+    vanished := func(i int, e error) error {
+        if _, ok := e.(*Error); !ok || opts.OnVanished == nil {
+            return e
+        }
+        gone[i] = true
+        opts.OnVanished(fmt.Errorf("GetList(): message %d: %w: %s", list[i].MsgNum, ErrVanished, e))
+        return nil
+    }
+
+    var truncated []int
+    err = c.sendBatches(cmds, batch, func(i int, lines []string, e error) error {
+        if e != nil {
+            return vanished(i, e)
+        }
+        if c.verifyHeaders && list[i].Size >= opts.RetrBelow && !hasHeaderEnd(lines) {
+            truncated = append(truncated, i)
+            return nil
+        }
+        text := strings.Join(lines, "\n")
+        if list[i].Size < opts.RetrBelow {
+            // fall back to the header if the body cannot be parsed
+            if email, e := parsemail.Parse(strings.NewReader(text)); e == nil {
+                list[i].Email = email
+                list[i].Complete = true
+                return nil
+            }
+        }
+        list[i].Email, list[i].ParseWarning = parseHeader(text)
+        return nil
+    })
+    if err != nil {
+        return
+    }
+
+    for _, i := range truncated {
+        var text string
+        if text, err = c.RETR(list[i].MsgNum); err != nil {
+            if err = vanished(i, err); err != nil {
+                return
+            }
+            continue
+        }
+        c.stats.HeaderFallbacks++
+        list[i].Email, list[i].ParseWarning = parseHeader(text)
+    }
+
+    if len(gone) > 0 {
+        kept := list[:0]
+        for i, item := range list {
+            if !gone[i] {
+                kept = append(kept, item)
+            }
+        }
+        list = kept
+    }
+    return
+}
+
+
+// GetRecent gets the most recent n messages in full (all if n <= 0), in the
+// same order as GetList: the most recent one first. Besides the parsed items,
+// it returns the text of each message as RETR would. A message whose body
+// cannot be parsed has only its header parsed and Complete false. If the
+// server supports PIPELINING, the RETR commands are sent in batches.
+func (c *Client) GetRecent(n int) (list []MailItem, texts []string, err error) {
+    list, err = c.recentItems(n)
+    if err != nil {
+        return
+    }
+
+    cmds := make([]string, len(list))
+    for i, item := range list {
+        cmds[i] = c.command("RETR %d\r\n", item.MsgNum)
+    }
+
+    texts = make([]string, len(list))
+    err = c.pipeline(cmds, func(i int, lines []string, e error) error {
+        if e != nil {
+            return e
+        }
+        texts[i] = strings.Join(lines, "\n")
+        // fall back to the header if the body cannot be parsed
+        if email, e := parsemail.Parse(strings.NewReader(texts[i])); e == nil {
+            list[i].Email = email
+            list[i].Complete = true
+            return nil
+        }
+        list[i].Email, list[i].ParseWarning = parseHeader(texts[i])
+        return nil
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+    return
+}
+
+
+// recentItems returns the most recent n messages (all if n <= 0) with their
+// message numbers and sizes, the most recent one first.
+func (c *Client) recentItems(n int) (list []MailItem, err error) {
     msgs, sizes, err := c.ListAll()
     if err != nil {
         return
@@ -129,10 +564,13 @@ func (c *Client) GetList(n int) (list []MailItem, err error) {
 
     num := len(msgs)
     if num != len(sizes) {
-        err = fmt.Errorf("GetList(): length of msgs and sizes are not the same.")
+        err = fmt.Errorf("GetList(): %w: %d and %d", ErrListMismatch, num, len(sizes))
         return
     }
 
+    // an empty maildrop gives an empty list, not nil
+    list = make([]MailItem, 0, num)
+
     for i := num - 1; i >= 0; i-- {
         var item = MailItem {
             Size    : sizes[i],
@@ -147,16 +585,219 @@ func (c *Client) GetList(n int) (list []MailItem, err error) {
         }
     }
 
-    for i := 0; i < len(list); i++ {
-        email, e := c.GetInfo(list[i].MsgNum)
-        if e != nil {
-            err = e
+    return
+}
+
+
+// ErrListMismatch is returned when a listing has a different number of
+// message numbers and sizes.
+var ErrListMismatch = errors.New("length of msgs and sizes are not the same")
+
+
+// ErrVanished is passed to GetListOptions.OnVanished for a listed message
+// that the server no longer has.
+var ErrVanished = errors.New("listed message is no longer available")
+
+
+// Default number of commands sent in one pipelined batch.
+const defaultPipelineBatch = 10
+
+
+// SetPipelineBatch sets how many commands are sent at once when the server
+// supports PIPELINING. If n <= 0, the default of 10 is used.
+func (c *Client) SetPipelineBatch(n int) {
+    c.pipelineBatch = n
+}
+
+
+// pipeline sends cmds (built by command), each of which must produce a
+// multiline response, and calls fn with the response of each command in
+// order. A negative response to a command is passed to fn as e. If the
+// server supports PIPELINING, the commands are sent in batches, otherwise one
+// at a time. If fn returns an error, the responses of the current batch are
+// still read so the connection stays usable, then the error is returned.
+func (c *Client) pipeline(cmds []string, fn func(i int, lines []string, e error) error) (err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return
+    }
+
+    batch, err := c.batchSize()
+    if err != nil {
+        return
+    }
+    return c.sendBatches(cmds, batch, fn)
+}
+
+
+// batchSize returns the number of commands to send at once: the pipeline
+// batch size if the server supports PIPELINING, 1 otherwise.
+func (c *Client) batchSize() (int, error) {
+    caps, err := c.capabilities()
+    if err != nil {
+        return 0, err
+    }
+    if !caps.Pipelining {
+        return 1, nil
+    }
+    if c.pipelineBatch <= 0 {
+        return defaultPipelineBatch, nil
+    }
+    return c.pipelineBatch, nil
+}
+
+
+// sendBatches does the work of pipeline, sending batch commands at once.
+func (c *Client) sendBatches(cmds []string, batch int, fn func(i int, lines []string, e error) error) (err error) {
+    if err = c.warmup(); err != nil {
+        return
+    }
+
+    var fnErr error
+    for start := 0; start < len(cmds) && fnErr == nil; start += batch {
+        end := start + batch
+        if end > len(cmds) {
+            end = len(cmds)
+        }
+
+        err = c.write(strings.Join(cmds[start:end], ""))
+        if err != nil {
             return
         }
 
-        list[i].Email = email
+        for i := start; i < end; i++ {
+            var lines []string
+            _, e := c.response(cmds[i])
+            if e != nil {
+                if _, ok := e.(*Error); !ok {
+                    return e
+                }
+            } else {
+                lines, err = c.ReadLines()
+                if err != nil {
+                    return
+                }
+            }
+
+            if fnErr == nil {
+                fnErr = fn(i, lines, e)
+            }
+        }
+    }
+
+    return fnErr
+}
+
+
+// Inventory returns a MailItem for every message with MsgNum, Size, UID and
+// the header set, but no body: a snapshot of the maildrop for planning a
+// sync. It issues UIDL and LIST, then TOP msg 0 for each message, pipelined
+// if the server supports it. The Date of the items is parsed leniently, so
+// SentTime works for them; it is zero if the message has no usable date. It
+// returns ErrUIDLUnsupported if the server lacks UIDL.
+func (c *Client) Inventory() (items []MailItem, err error) {
+    return c.headerItems(true)
+}
+
+
+// SearchHeaders returns the messages for which match returns true, oldest
+// first, deciding only on the header: the items passed to match have the
+// parsed header fields (Subject, From, Date...) and MsgNum, Size and, if the
+// server supports UIDL, UID set. The headers are fetched with TOP msg 0,
+// pipelined if the server supports it, so no body is downloaded.
+func (c *Client) SearchHeaders(match func(MailItem) bool) (found []MailItem, err error) {
+    items, err := c.headerItems(false)
+    if err != nil {
+        return
+    }
+    found = make([]MailItem, 0)
+    for _, item := range items {
+        if match(item) {
+            found = append(found, item)
+        }
+    }
+    return
+}
+
+
+// headerItems returns an item with the parsed header for every message. If
+// needUID is false, a server without UIDL gives items without UID.
+func (c *Client) headerItems(needUID bool) (items []MailItem, err error) {
+    items = make([]MailItem, 0)
+    err = c.headerEach(needUID, func(item MailItem) error {
+        items = append(items, item)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return
+}
+
+
+// headerEach works like headerItems, but passes the items to fn as they
+// arrive instead of collecting them.
+func (c *Client) headerEach(needUID bool, fn func(MailItem) error) (err error) {
+    uidMsgs, uids, err := c.uidlAll()
+    if errors.Is(err, ErrUIDLUnsupported) && !needUID {
+        err = nil
+    }
+    if err != nil {
+        return
+    }
+    msgs, sizes, err := c.ListAll()
+    if err != nil {
+        return
+    }
+
+    uidOf := make(map[int]string, len(uidMsgs))
+    for i, m := range uidMsgs {
+        uidOf[m] = uids[i]
+    }
+    cmds := make([]string, len(msgs))
+    for i, m := range msgs {
+        cmds[i] = c.command("TOP %d 0\r\n", m)
     }
 
+    return c.pipeline(cmds, func(i int, lines []string, e error) error {
+        if e != nil {
+            return e
+        }
+        item := MailItem{MsgNum: msgs[i], Size: sizes[i], UID: uidOf[msgs[i]]}
+        item.Email, item.ParseWarning = parseHeader(strings.Join(lines, "\n"))
+        return fn(item)
+    })
+}
+
+
+// MessageNumbers returns the numbers of the messages currently in the
+// maildrop, in ascending order. Unlike 1..count from STAT, it skips messages
+// marked as deleted in this session.
+func (c *Client) MessageNumbers() (msgs []int, err error) {
+    msgs, _, err = c.ListAll()
     return
 }
 
+
+// EstimateDownload returns the total size in octets of the given messages,
+// using a single LIST command. It fails if one of the messages does not
+// exist.
+func (c *Client) EstimateDownload(msgs []int) (totalBytes int64, err error) {
+    all, sizes, err := c.ListAll()
+    if err != nil {
+        return
+    }
+
+    size := make(map[int]int, len(all))
+    for i, m := range all {
+        size[m] = sizes[i]
+    }
+
+    for _, m := range msgs {
+        s, ok := size[m]
+        if !ok {
+            return 0, fmt.Errorf("EstimateDownload(): no such message: %d", m)
+        }
+        totalBytes += int64(s)
+    }
+    return
+}
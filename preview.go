@@ -0,0 +1,111 @@
+package pop3
+
+import (
+    "html"
+    "strings"
+    "unicode/utf8"
+
+    "github.com/m3ng9i/parsemail"
+)
+
+// Preview returns a plain text snippet of the given message of at most
+// maxChars characters, e.g. for notifications. It first fetches the header
+// and the start of the body with TOP, and falls back to the whole message
+// (RETR) when that is not enough: when the server lacks TOP, the truncated
+// message cannot be parsed, or it only has an HTML body, which cut off may
+// lose its text. The text is taken from BestText, HTML is reduced to its
+// text, whitespace is collapsed and the result truncated at a word boundary,
+// ending with "…". maxChars <= 0 means no limit.
+//
+// A message without a text body, e.g. one that only carries an image, has
+// the empty preview, as has one that cannot be parsed.
+func (c *Client) Preview(msg, maxChars int) (string, error) {
+    email, ok := c.previewTop(msg, maxChars)
+    if !ok {
+        text, err := c.RETR(msg)
+        if err != nil {
+            return "", err
+        }
+        if email, err = parsemail.Parse(strings.NewReader(text)); err != nil {
+            return "", nil
+        }
+    }
+
+    text, isHTML, err := MailItem{Email: email}.BestText()
+    if err == ErrNoText {
+        return "", nil
+    }
+    if isHTML {
+        text = htmlToText(text)
+    }
+    return truncateWords(strings.Join(strings.Fields(text), " "), maxChars), nil
+}
+
+
+// previewTop fetches enough of the message with TOP to preview maxChars
+// characters of its plain text body, if it has one.
+func (c *Client) previewTop(msg, maxChars int) (email parsemail.Email, ok bool) {
+    if maxChars <= 0 {
+        return
+    }
+    // lines are often short or blank; multipart headers take some more
+    text, err := c.TOP(msg, maxChars/20+30)
+    if err != nil {
+        return
+    }
+    email, err = parsemail.Parse(strings.NewReader(text))
+    return email, err == nil && email.TextBody != ""
+}
+
+
+// htmlToText returns the text of an HTML document, without tags, comments,
+// scripts and style sheets, and with entities decoded. Tags become spaces
+// so that words of adjacent blocks do not run together.
+func htmlToText(s string) string {
+    var b strings.Builder
+    for s != "" {
+        i := strings.IndexByte(s, '<')
+        if i < 0 {
+            b.WriteString(s)
+            break
+        }
+        b.WriteString(s[:i])
+        b.WriteByte(' ')
+        s = s[i:]
+
+        end := ">"
+        lower := strings.ToLower(s)
+        switch {
+        case strings.HasPrefix(lower, "<!--"):
+            end = "-->"
+        case strings.HasPrefix(lower, "<script"):
+            end = "</script>"
+        case strings.HasPrefix(lower, "<style"):
+            end = "</style>"
+        }
+        j := strings.Index(lower, end)
+        if j < 0 {
+            break
+        }
+        s = s[j+len(end):]
+    }
+    return html.UnescapeString(b.String())
+}
+
+
+// truncateWords shortens s to at most max characters, cutting at the last
+// space before the limit if there is one and appending "…".
+func truncateWords(s string, max int) string {
+    if max <= 0 || utf8.RuneCountInString(s) <= max {
+        return s
+    }
+    cut := 0
+    for i := 0; i < max-1; i++ {
+        _, size := utf8.DecodeRuneInString(s[cut:])
+        cut += size
+    }
+    if i := strings.LastIndexByte(s[:cut], ' '); i > 0 {
+        cut = i
+    }
+    return strings.TrimRight(s[:cut], " ") + "…"
+}
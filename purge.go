@@ -0,0 +1,52 @@
+package pop3
+
+import (
+    "time"
+)
+
+// SetPurgeUndated sets whether PurgeOlderThan deletes messages whose Date
+// header is missing or cannot be parsed. By default they are kept.
+func (c *Client) SetPurgeUndated(on bool) {
+    c.purgeUndated = on
+}
+
+
+// PurgeOlderThan marks for deletion every message whose Date header is older
+// than d, implementing the "leave on server, delete after some days" policy.
+// The dates are read with TOP and parsed leniently (see ParseDate), so
+// message bodies are not downloaded. The deletions take effect at QUIT (or
+// CommitDeletes). In read-only mode, it returns ErrReadOnly at once, before
+// anything is sent.
+func (c *Client) PurgeOlderThan(d time.Duration) (deleted []int, err error) {
+    if c.readOnly {
+        return nil, ErrReadOnly
+    }
+    msgs, _, err := c.ListAll()
+    if err != nil {
+        return
+    }
+
+    limit := c.now().Add(-d)
+    for _, m := range msgs {
+        var text string
+        text, err = c.TOP(m, 0)
+        if err != nil {
+            return
+        }
+
+        date, e := headerDate(text)
+        if e != nil {
+            if !c.purgeUndated {
+                continue
+            }
+        } else if !date.Before(limit) {
+            continue
+        }
+
+        if err = c.DELE(m); err != nil {
+            return
+        }
+        deleted = append(deleted, m)
+    }
+    return
+}
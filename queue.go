@@ -0,0 +1,89 @@
+package pop3
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// ErrNotQueueable is returned by QueueCommand for commands whose response is
+// not a single status line, such as RETR, TOP, CAPA, AUTH, LIST and UIDL
+// without a message number, or LANG without a language.
+var ErrNotQueueable = errors.New("command has a multiline response and cannot be queued")
+
+// ErrReaderOpen is returned by DELE while a message is being read; the
+// command can be queued with QueueCommand instead.
+var ErrReaderOpen = errors.New("a message is being read, close its reader first")
+
+// QueueCommand runs a command whose response is a single status line, such
+// as DELE, NOOP or RSET, without disturbing a message being read. POP3 allows
+// one command at a time, so while a reader returned by RetrReader (or the
+// like) is open, the command is put in a queue instead of being sent, and
+// QueueCommand returns nil; a nil error therefore does not mean that the
+// command succeeded. Commands with a multiline response are rejected with
+// ErrNotQueueable.
+//
+// Queued commands are sent in the order they were queued, as soon as the
+// response in flight has been received: when the reader reaches its end or
+// is closed, and with RetrRange and BulkRetrSmall after the current batch of
+// pipelined messages. They run before the next command of the caller. Their
+// first error is returned by Close of the reader (or by RetrRange and
+// BulkRetrSmall); reading to io.EOF alone does not report it. If the
+// connection breaks before the response ends, the queued commands are
+// dropped and the reader returns the read error.
+//
+// With nothing in flight, the command is sent at once and its error
+// returned. Like the Client itself, the queue is not safe for concurrent
+// use: it serves interleaved calls, e.g. a user deleting one message while a
+// UI loop streams another.
+func (c *Client) QueueCommand(format string, args ...interface{}) error {
+    cmd := c.command(format, args...)
+    if multiline(cmd) {
+        return ErrNotQueueable
+    }
+    if c.active == nil && !c.holdQueue {
+        _, err := c.Cmd("%s", cmd)
+        return err
+    }
+    if c.readOnly && verb(cmd) == "DELE" {
+        return ErrReadOnly
+    }
+    c.queue = append(c.queue, cmd)
+    return nil
+}
+
+
+// multiline reports whether the response to cmd may be more than a status
+// line.
+func multiline(cmd string) bool {
+    switch verb(cmd) {
+    case "", "RETR", "TOP", "CAPA", "AUTH":
+        return true
+    case "LIST", "UIDL", "LANG":
+        return len(strings.Fields(cmd)) < 2
+    }
+    return false
+}
+
+
+// runQueue sends the queued commands one by one and returns the first error.
+// Negative responses do not stop the queue, other errors do.
+func (c *Client) runQueue() (err error) {
+    for len(c.queue) > 0 {
+        cmd := c.queue[0]
+        c.queue = c.queue[1:]
+        _, e := c.Cmd("%s", cmd)
+        if e == nil {
+            continue
+        }
+        if err == nil {
+            err = fmt.Errorf("queued %s: %w", verb(cmd), e)
+        }
+        if _, ok := e.(*Error); !ok {
+            c.queue = nil
+            return
+        }
+    }
+    c.queue = nil
+    return
+}
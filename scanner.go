@@ -0,0 +1,73 @@
+package pop3
+
+import (
+    "bufio"
+    "io"
+)
+
+// LineScanner reads a message line by line from the connection, like a
+// bufio.Scanner. Lines are dot-unstuffed and have their line ending removed.
+type LineScanner struct {
+    r    *dotReader
+    br   *bufio.Reader
+    line string
+    err  error
+}
+
+
+// RetrLines sends RETR for the given message and returns a scanner over its
+// lines, which are read from the connection as Scan is called. The scanner
+// must be read to the end or closed before the client is used again.
+func (c *Client) RetrLines(msg int) (*LineScanner, error) {
+    r, err := c.RetrReader(msg)
+    if err != nil {
+        return nil, err
+    }
+    dr := r.(*dotReader)
+    return &LineScanner{r: dr, br: bufio.NewReader(dr)}, nil
+}
+
+
+// Scan advances to the next line, which is then available through Text. It
+// returns false at the end of the message or on error. A line longer than
+// the limit set with SetMaxLineLength stops the scan with ErrLineTooLong.
+func (s *LineScanner) Scan() bool {
+    if s.err != nil {
+        return false
+    }
+
+    max := s.r.c.maxLine
+    if max <= 0 {
+        max = defaultMaxLineLength
+    }
+    l, err := readLine(s.br, max)
+    if err != nil {
+        s.err = err
+        return false
+    }
+    s.line = string(l)
+    return true
+}
+
+
+// Text returns the line read by the last call to Scan.
+func (s *LineScanner) Text() string {
+    return s.line
+}
+
+
+// Err returns the first error met by Scan, or nil if the message was read
+// completely.
+func (s *LineScanner) Err() error {
+    if s.err == io.EOF {
+        return nil
+    }
+    return s.err
+}
+
+
+// Close discards the unread rest of the message, so that the connection can
+// be used for the next command.
+func (s *LineScanner) Close() error {
+    return s.r.Close()
+}
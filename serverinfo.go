@@ -0,0 +1,67 @@
+package pop3
+
+import (
+    "regexp"
+    "strings"
+)
+
+// ServerInfo is a best-effort guess of the server software, made from the
+// greeting and the IMPLEMENTATION capability.
+type ServerInfo struct {
+    Software string // "Dovecot", "Courier", "qpopper", "Exchange" or "unknown"
+    Version  string // empty if the server does not tell
+    Raw      string // the greeting, followed by the IMPLEMENTATION line if any
+}
+
+var serverSignatures = []struct {
+    software string
+    pattern  *regexp.Regexp
+}{
+    {"Dovecot", regexp.MustCompile(`(?i)\bdovecot\b`)},
+    {"Courier", regexp.MustCompile(`(?i)\bcourier\b|^hello there\.?$`)},
+    {"qpopper", regexp.MustCompile(`(?i)\bqpopper\b`)},
+    {"Exchange", regexp.MustCompile(`(?i)\bmicrosoft exchange\b|\bexchange\b.*\bpop3\b`)},
+}
+
+var versionPattern = regexp.MustCompile(`(?i)\b([0-9]+(?:\.[0-9]+)+[a-z0-9\-]*)`)
+
+// The APOP timestamp in a greeting looks like a version number.
+var timestampPattern = regexp.MustCompile(`<[^>]*>`)
+
+// ServerInfo guesses the server software and version. It uses the greeting
+// and, if CAPA has been issued before, the IMPLEMENTATION capability; it
+// never sends a command itself.
+func (c *Client) ServerInfo() ServerInfo {
+    var impl string
+    if c.caps != nil {
+        impl = c.caps.Implementation
+    }
+    return guessServer(c.greeting, impl)
+}
+
+func guessServer(greeting, impl string) (info ServerInfo) {
+    info.Raw = greeting
+    if impl != "" {
+        info.Raw += "\n" + impl
+    }
+    info.Software = "unknown"
+
+    // The IMPLEMENTATION line is more specific than the greeting, which
+    // administrators often customize.
+    for _, text := range []string{impl, greeting} {
+        text = strings.TrimSpace(timestampPattern.ReplaceAllString(text, ""))
+        if text == "" {
+            continue
+        }
+        for _, sig := range serverSignatures {
+            if sig.pattern.MatchString(text) {
+                info.Software = sig.software
+                if m := versionPattern.FindStringSubmatch(text); m != nil {
+                    info.Version = m[1]
+                }
+                return
+            }
+        }
+    }
+    return
+}
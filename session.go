@@ -0,0 +1,266 @@
+package pop3
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "net"
+    "time"
+)
+
+// ErrNoDialer is returned by Reconnect for clients created with NewClient,
+// which do not know how to open a new connection.
+var ErrNoDialer = errors.New("client cannot reconnect: not created by a Dial function")
+
+// ErrNotConnected is returned when a command is sent by a client without a
+// connection, such as one made by ConfigClone before Reconnect.
+var ErrNotConnected = errors.New("client is not connected")
+
+
+// SetReauth sets the function used by Reconnect to authenticate the new
+// session. Auth sets it automatically; use SetReauth for other ways of
+// logging in. A nil f leaves new sessions unauthenticated.
+func (c *Client) SetReauth(f func(*Client) error) {
+    c.reauth = f
+}
+
+
+// Reconnect closes the current connection (without QUIT, so pending
+// deletions are discarded), dials the server again and authenticates the new
+// session with the reauth function, if any. Only clients created by one of
+// the Dial functions can reconnect.
+func (c *Client) Reconnect() error {
+    if c.dial == nil {
+        return ErrNoDialer
+    }
+    if c.conn != nil && c.state != StateClosed {
+        c.conn.Close()
+    }
+    c.state = StateClosed
+
+    conn, err := c.dial()
+    if err != nil {
+        return err
+    }
+    err = c.greet(conn)
+    if err == nil && c.stls != nil {
+        err = c.StartTLS(c.stls)
+    }
+    if err != nil {
+        conn.Close()
+        c.state = StateClosed
+        return err
+    }
+
+    if c.reauth != nil {
+        return c.reauth(c)
+    }
+    return nil
+}
+
+
+// ConfigClone returns a new, unconnected client with the configuration of c:
+// the dial and reauth functions, logger and name, rate limit (shared, as with
+// Coordinator), read-only and strict mode, line ending, maximum line length,
+// idle timeout, pipeline batch size, warmup, size and header verification
+// and purge settings. The clone shares no connection state with c; it has no
+// connection, session, cached capabilities or statistics, and is in
+// StateClosed until Reconnect dials and authenticates it. Clients created
+// with NewClient have no dial function, so their clones cannot connect.
+func (c *Client) ConfigClone() *Client {
+    clone := &Client{
+        state:         StateClosed,
+        pipelineBatch: c.pipelineBatch,
+        lineEnding:    c.lineEnding,
+        readOnly:      c.readOnly,
+        purgeUndated:  c.purgeUndated,
+        warmupStat:    c.warmupStat,
+        verifySize:    c.verifySize,
+        strict:        c.strict,
+        verifyHeaders: c.verifyHeaders,
+        maxLine:       c.maxLine,
+        timeout:       c.timeout,
+        logger:        c.logger,
+        name:          c.name,
+        limiter:       c.limiter,
+        dial:          c.dial,
+        tlsAddr:       c.tlsAddr,
+        stls:          c.stls,
+        reauth:        c.reauth,
+        clock:         c.clock,
+        after:         c.after,
+    }
+    if c.idle != nil {
+        clone.idle = &idleTimer{d: c.idle.d, now: clone.now, afterFunc: clone.afterFunc, quit: clone.command("QUIT\r\n")}
+    }
+    return clone
+}
+
+
+// ReconnectWith works like Reconnect, but first replaces the TLS config used
+// to dial and the reauth function, e.g. to rotate a client certificate or
+// refresh a token in a long-running process. A nil cfg or reauth keeps the
+// current one. The replacements stay in effect for later reconnects. A new
+// TLS config can only be given to clients created by one of the DialTLS
+// functions or upgraded with StartTLS; for others ReconnectWith returns
+// ErrNoDialer.
+func (c *Client) ReconnectWith(cfg *tls.Config, reauth func(*Client) error) error {
+    if cfg != nil {
+        switch {
+        case c.stls != nil:
+            c.stls = cfg
+        case c.tlsAddr != "":
+            addr := c.tlsAddr
+            c.dial = func() (net.Conn, error) {
+                return tls.Dial("tcp", addr, cfg)
+            }
+        default:
+            return ErrNoDialer
+        }
+    }
+    if reauth != nil {
+        c.reauth = reauth
+    }
+    return c.Reconnect()
+}
+
+
+// CommitDeletes ends the session with QUIT, so that messages marked with DELE
+// are actually removed, then reconnects and authenticates again so the
+// client can keep working in a fresh session.
+//
+// Message numbers are reassigned by the server in the new session: numbers
+// obtained before the call must not be used afterwards. Use UIDL to track
+// messages across the commit.
+func (c *Client) CommitDeletes() error {
+    if err := c.requireState(StateTransaction); err != nil {
+        return err
+    }
+    if c.dial == nil {
+        return ErrNoDialer
+    }
+    if err := c.QUIT(); err != nil {
+        return err
+    }
+    return c.Reconnect()
+}
+
+
+// SetWarmupStat makes the client send STAT right after authentication,
+// before the first command of the transaction state. This is a compatibility
+// shim for servers which only build their maildrop index on STAT and reject
+// RETR and friends otherwise; compliant servers do not need it.
+func (c *Client) SetWarmupStat(on bool) {
+    c.warmupStat = on
+}
+
+
+// warmup sends the STAT requested by SetWarmupStat, if it is due.
+func (c *Client) warmup() error {
+    if !c.warmupStat || c.warmedUp || c.state != StateTransaction {
+        return nil
+    }
+    c.warmedUp = true
+    _, err := c.Cmd("STAT\r\n")
+    return err
+}
+
+
+// Shutdown is the teardown path for clients using background helpers: it
+// stops the idle timeout (see SetIdleTimeout) and the prefetcher of an open
+// MessageIter, waits for them, ends the session with QUIT and closes the
+// connection. If ctx ends first, the connection is closed without waiting
+// any longer and ctx.Err() is returned. Calling Shutdown again does nothing
+// and returns nil.
+func (c *Client) Shutdown(ctx context.Context) error {
+    if c.shutDown {
+        return nil
+    }
+    c.shutDown = true
+
+    if c.idle != nil {
+        c.idle.stop()
+    }
+
+    if it := c.prefetching; it != nil {
+        // the prefetcher may be in the middle of a transfer
+        done := make(chan struct{})
+        go func() {
+            it.Close()
+            close(done)
+        }()
+        select {
+        case <-done:
+        case <-ctx.Done():
+            c.conn.Close()
+            <-done
+            c.state = StateClosed
+            return ctx.Err()
+        }
+    }
+
+    if c.conn == nil || c.State() == StateClosed {
+        return nil
+    }
+    err := c.withContext(ctx, func() error {
+        _, err := c.Cmd("QUIT\r\n")
+        return err
+    })
+    c.conn.Close()
+    c.state = StateClosed
+    return err
+}
+
+
+// How long IsConnected waits for the connection to report its state.
+const connectedProbe = time.Millisecond
+
+
+// IsConnected checks, without sending a command, whether the connection is
+// still open: it reads from the connection with a very short deadline, which
+// times out if the connection is open and idle, but ends at once if the
+// server has closed it. A closed connection puts the client in StateClosed.
+// Data sent by the server without being asked is kept for the next command.
+//
+// The check is best effort and cheaper than NOOP: a connection which broke
+// without the server closing it properly (e.g. a dead link) looks connected
+// until the next command fails.
+func (c *Client) IsConnected() bool {
+    if c.conn == nil || c.State() == StateClosed {
+        return false
+    }
+    // a prefetcher reading the connection keeps it busy, see MessagesPrefetch
+    if c.active != nil || c.checkShared() != nil || c.bin.Buffered() > 0 {
+        return true
+    }
+
+    c.setReadDeadline(time.Now().Add(connectedProbe))
+    _, err := c.bin.Peek(1)
+    c.setReadDeadline(time.Time{})
+
+    var ne net.Error
+    if err == nil || errors.As(err, &ne) && ne.Timeout() {
+        return true
+    }
+    c.conn.Close()
+    c.state = StateClosed
+    return false
+}
+
+
+// DetectConcurrentMutation sends STAT and reports whether the number of
+// messages differs from expectedCount, e.g. the count seen when the message
+// numbers about to be used were enumerated. A change means the message
+// numbers may no longer refer to the same messages, and the maildrop should
+// be enumerated again. Note that STAT does not count messages marked as
+// deleted in this session, so expectedCount must not either.
+//
+// RFC 1939 has the server lock the maildrop for the session, so a change is
+// only seen on servers which do not, or which let other sessions in anyway.
+func (c *Client) DetectConcurrentMutation(expectedCount int) (changed bool, currentCount int, err error) {
+    currentCount, _, err = c.STAT()
+    if err != nil {
+        return false, 0, err
+    }
+    return currentCount != expectedCount, currentCount, nil
+}
@@ -0,0 +1,79 @@
+package pop3
+
+import (
+    "bufio"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// ErrSTLSStripped is returned by StartTLS when the server rejects STLS. This
+// is what an attacker between client and server would make it do to keep
+// the session unencrypted, so the connection is closed rather than used in
+// plain text.
+var ErrSTLSStripped = errors.New("server rejected STLS")
+
+// StartTLS upgrades the connection to TLS with the STLS command (RFC 2595).
+// It must be called in the authorization state, before logging in. cfg must
+// name the server in ServerName, as the certificate is checked against it.
+// Capabilities cached before the upgrade may have been tampered with: they
+// are dropped and fetched again over TLS. In strict mode (see SetStrict) the
+// server must then announce a way to log in (USER or SASL), otherwise
+// ErrProtocolViolation is returned. Reconnect repeats the upgrade on new
+// connections.
+//
+// If the server rejects STLS, StartTLS returns an error wrapping both
+// ErrSTLSStripped and the *Error of the response. In that case, and when
+// the handshake fails, the connection is closed and the client is in
+// StateClosed, so that it cannot fall back to logging in without TLS.
+func (c *Client) StartTLS(cfg *tls.Config) error {
+    if err := c.requireState(StateAuthorization); err != nil {
+        return err
+    }
+    if _, err := c.Cmd("STLS\r\n"); err != nil {
+        var e *Error
+        if errors.As(err, &e) {
+            c.conn.Close()
+            c.state = StateClosed
+            return fmt.Errorf("%w: %w", ErrSTLSStripped, err)
+        }
+        return err
+    }
+    if c.bin.Buffered() > 0 {
+        // anything sent ahead of the handshake would be taken as sent over
+        // TLS: a known command injection attack
+        c.conn.Close()
+        c.state = StateClosed
+        return fmt.Errorf("%w: data after STLS response", ErrProtocolViolation)
+    }
+
+    tconn := tls.Client(c.conn, cfg)
+    if d := c.readTimeout(); d > 0 {
+        c.conn.SetDeadline(time.Now().Add(d))
+    }
+    err := tconn.Handshake()
+    c.conn.SetDeadline(time.Time{})
+    if err != nil {
+        c.conn.Close()
+        c.state = StateClosed
+        return err
+    }
+
+    c.conn = tconn
+    c.bin = bufio.NewReader(meter{c})
+    c.caps = nil
+    if c.idle != nil {
+        c.idle.start(tconn)
+    }
+    c.stls = cfg
+
+    caps, err := c.capabilities()
+    if err != nil {
+        return err
+    }
+    if c.strict && !caps.User && len(caps.SASL) == 0 {
+        return fmt.Errorf("%w: no login method announced after STLS", ErrProtocolViolation)
+    }
+    return nil
+}
@@ -0,0 +1,79 @@
+package pop3
+
+import (
+    "errors"
+    "time"
+)
+
+// Stats holds transfer statistics of a client, accumulated over all its
+// sessions.
+type Stats struct {
+    BytesRead int64         // bytes received from the server
+    ReadTime  time.Duration // time spent waiting for and receiving them
+
+    // headers fetched again with RETR because TOP cut them off, see
+    // SetVerifyHeaders
+    HeaderFallbacks int
+}
+
+
+// Throughput returns the observed download rate in bytes per second, or 0 if
+// nothing has been measured yet.
+func (s Stats) Throughput() float64 {
+    if s.ReadTime <= 0 {
+        return 0
+    }
+    return float64(s.BytesRead) / s.ReadTime.Seconds()
+}
+
+
+// Stats returns the transfer statistics of the client.
+func (c *Client) Stats() Stats {
+    return c.stats
+}
+
+
+// meter counts the bytes read from the connection of a client and the time
+// it takes.
+type meter struct {
+    c *Client
+}
+
+
+func (m meter) Read(p []byte) (n int, err error) {
+    m.c.idleUse(1)
+    defer m.c.idleUse(-1)
+    if d := m.c.readTimeout(); d > 0 && !m.c.pinned {
+        m.c.conn.SetReadDeadline(time.Now().Add(d))
+    }
+    start := m.c.now()
+    n, err = m.c.conn.Read(p)
+    m.c.stats.BytesRead += int64(n)
+    m.c.stats.ReadTime += m.c.now().Sub(start)
+    if m.c.limiter != nil && n > 0 {
+        if e := m.c.sleep(m.c.limiter.take(m.c.now(), n)); err == nil {
+            err = e
+        }
+    }
+    return
+}
+
+
+// ErrNoThroughput is returned by ETA before any data has been received.
+var ErrNoThroughput = errors.New("no throughput measured yet")
+
+
+// ETA estimates how long downloading the given messages will take at the
+// throughput observed so far (see Stats). The sizes are taken from a single
+// LIST command. It returns ErrNoThroughput if nothing has been measured yet.
+func (c *Client) ETA(msgs []int) (time.Duration, error) {
+    rate := c.stats.Throughput()
+    if rate <= 0 {
+        return 0, ErrNoThroughput
+    }
+    total, err := c.EstimateDownload(msgs)
+    if err != nil {
+        return 0, err
+    }
+    return time.Duration(float64(total) / rate * float64(time.Second)), nil
+}
@@ -0,0 +1,571 @@
+// Streaming access to multiline responses.
+package pop3
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "mime"
+    "net/textproto"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// dotReader reads the body of a multiline response straight from the
+// connection. It removes the dot-stuffing and stops at the terminating line;
+// line endings are passed on as sent by the server.
+type dotReader struct {
+    c         *Client
+    pending   []byte // unread part of the current line, points into c.bin
+    lineStart bool   // the next byte read from c.bin starts a line
+    err       error  // io.EOF once the terminator has been read
+    queueErr  error  // first error of the queued commands run at the end
+
+    expect  int64 // size announced by LIST, -1 if not checked
+    limit   int64 // size announced in the status line, -1 if not checked
+    n       int64 // bytes of content so far
+    lines   int64 // complete lines so far
+    crlf    int64 // complete lines ending in CRLF so far
+    stuffed int64 // lines whose leading dot was removed so far
+}
+
+
+func (c *Client) newDotReader() *dotReader {
+    return &dotReader{c: c, lineStart: true, expect: -1, limit: -1}
+}
+
+
+// ErrSizeMismatch is returned at the end of a message whose size differs from
+// the size reported by LIST by more than the tolerance, see SetVerifySize.
+var ErrSizeMismatch = errors.New("message size does not match LIST size")
+
+
+// SetVerifySize turns size verification of streamed messages on or off.
+// When on, RetrReader (and RetrTo) first asks for the message size with
+// LIST, and the reader returns ErrSizeMismatch instead of io.EOF if the
+// received size is off by more than one octet per line. The tolerance covers
+// servers which count line endings differently from how they send them.
+func (c *Client) SetVerifySize(on bool) {
+    c.verifySize = on
+}
+
+
+// octets returns the octet count at the start of a RETR status text like
+// "1234 octets", or -1 if there is none.
+func octets(status string) int64 {
+    fs := strings.Fields(status)
+    if len(fs) == 0 {
+        return -1
+    }
+    n, err := strconv.ParseInt(fs[0], 10, 64)
+    if err != nil || n < 0 {
+        return -1
+    }
+    return n
+}
+
+
+// sizeOK reports whether n bytes in the given number of lines plausibly
+// match the size announced by LIST.
+func sizeOK(n, lines, expect int64) bool {
+    diff := n - expect
+    if diff < 0 {
+        diff = -diff
+    }
+    return diff <= lines
+}
+
+
+func (r *dotReader) Read(p []byte) (n int, err error) {
+    for len(r.pending) == 0 {
+        if r.err != nil {
+            return 0, r.err
+        }
+        r.fill()
+    }
+    n = copy(p, r.pending)
+    r.pending = r.pending[n:]
+    return n, nil
+}
+
+
+// fill reads the next line, or fragment of a long line, into r.pending.
+func (r *dotReader) fill() {
+    b, err := r.c.bin.ReadSlice('\n')
+    if err == bufio.ErrBufferFull {
+        if r.lineStart && len(b) > 0 && b[0] == '.' {
+            b = b[1:]
+            r.stuffed++
+        }
+        r.lineStart = false
+        r.pending = b
+        r.n += int64(len(b))
+        return
+    }
+    if err != nil {
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            err = ErrUnexpectedEOF
+        }
+        // the connection is broken, queued commands cannot be sent
+        r.c.queue = nil
+        r.finish(err)
+        return
+    }
+
+    if r.lineStart && len(b) > 0 && b[0] == '.' {
+        if string(b) == ".\r\n" || string(b) == ".\n" {
+            if r.limit >= 0 && r.n > r.limit+r.lines {
+                r.finish(fmt.Errorf("%w: received %d octets, status line said %d", ErrProtocolViolation, r.n, r.limit))
+                return
+            }
+            if r.expect >= 0 && !sizeOK(r.n, r.lines, r.expect) {
+                r.finish(fmt.Errorf("%w: received %d octets, LIST said %d", ErrSizeMismatch, r.n, r.expect))
+                return
+            }
+            r.finish(io.EOF)
+            return
+        }
+        b = b[1:]
+        r.stuffed++
+    }
+    r.lineStart = true
+    r.pending = b
+    r.n += int64(len(b))
+    r.lines++
+    if bytes.HasSuffix(b, []byte("\r\n")) {
+        r.crlf++
+    }
+}
+
+
+// finish ends the response with err, io.EOF meaning a complete response,
+// and sends the commands queued meanwhile, see QueueCommand.
+func (r *dotReader) finish(err error) {
+    r.err = err
+    if r.c.active == r {
+        r.c.active = nil
+        if !r.c.holdQueue {
+            r.queueErr = r.c.runQueue()
+        }
+    }
+}
+
+
+// Close reads and discards the rest of the response, so that the connection
+// can be used for the next command. It returns the error of the response or
+// else of the commands queued during it.
+func (r *dotReader) Close() error {
+    r.pending = nil
+    for r.err == nil {
+        r.fill()
+        r.pending = nil
+    }
+    if r.err != io.EOF {
+        return r.err
+    }
+    err := r.queueErr
+    r.queueErr = nil
+    return err
+}
+
+
+// RetrReader sends RETR for the given message and returns a reader over the
+// message, which is read directly from the connection as the caller consumes
+// it. Dot-stuffing is removed and the CRLF line endings are kept; all other
+// bytes are passed on unchanged, so 8-bit and binary content is safe. The
+// reader must be read to the end or closed before the client is used again,
+// except for QueueCommand, which waits for the end of the message; Close
+// discards the unread rest of the message.
+func (c *Client) RetrReader(msg int) (io.ReadCloser, error) {
+    r, _, err := c.RetrReaderSize(msg)
+    return r, err
+}
+
+
+// RetrReaderSize works like RetrReader, and also returns the size of the
+// message if the server states it in the RETR status line, as many do
+// ("+OK 1234 octets"), or -1. This saves a LIST command, e.g. to show
+// progress. The size is as reported by the server and not checked (but see
+// SetStrict); it may differ from the bytes read by a few, e.g. for a
+// different line ending count.
+func (c *Client) RetrReaderSize(msg int) (rc io.ReadCloser, size int64, err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return nil, -1, err
+    }
+    if err = c.checkMsg(msg); err != nil {
+        return nil, -1, err
+    }
+
+    expect := int64(-1)
+    if c.verifySize {
+        listSize, err := c.LIST(msg)
+        if err != nil {
+            return nil, -1, err
+        }
+        expect = int64(listSize)
+    }
+
+    status, err := c.Cmd("RETR %d\r\n", msg)
+    if err != nil {
+        return nil, -1, err
+    }
+    size = octets(status)
+    r := c.newDotReader()
+    r.expect = expect
+    if c.strict {
+        r.limit = size
+    }
+    c.active = r
+    return r, size, nil
+}
+
+
+// ErrMultipart is returned by RetrDecodedReader for multipart messages, whose
+// parts must be read with a MIME-aware method such as GetMail.
+var ErrMultipart = errors.New("message is multipart, use GetMail to read its parts")
+
+
+// RetrDecodedReader works like RetrReader for single part messages, but
+// skips the header and removes the Content-Transfer-Encoding (base64 or
+// quoted-printable) of the body, so the reader returns the decoded content,
+// e.g. the bytes of a PDF. For multipart messages, it discards the message
+// and returns ErrMultipart.
+func (c *Client) RetrDecodedReader(msg int) (io.ReadCloser, error) {
+    r, err := c.RetrReader(msg)
+    if err != nil {
+        return nil, err
+    }
+
+    br := bufio.NewReader(r)
+    header, err := textproto.NewReader(br).ReadMIMEHeader()
+    if err != nil && err != io.EOF {
+        r.Close()
+        return nil, err
+    }
+    if mediaType, _, e := mime.ParseMediaType(header.Get("Content-Type")); e == nil && strings.HasPrefix(mediaType, "multipart/") {
+        r.Close()
+        return nil, ErrMultipart
+    }
+
+    return struct {
+        io.Reader
+        io.Closer
+    }{decodeTransfer(header, br), r}, nil
+}
+
+
+// How long AbortRetr waits for the rest of a message before giving up on
+// the connection.
+const abortDrainTimeout = 5 * time.Second
+
+
+// AbortRetr abandons the message being read through a reader returned by
+// RetrReader. POP3 has no way to cancel a transfer, so the rest of the
+// message still has to be received: AbortRetr reads and discards it, which
+// keeps the connection usable. If that takes longer than a few seconds (e.g.
+// the rest of a huge message on a slow link), it closes the connection
+// instead; the client is then in the closed state and must Reconnect, and
+// pending deletions are lost. AbortRetr does nothing if no RETR is in flight.
+func (c *Client) AbortRetr() error {
+    r := c.active
+    if r == nil {
+        return nil
+    }
+
+    c.setReadDeadline(time.Now().Add(abortDrainTimeout))
+    err := r.Close()
+    c.setReadDeadline(time.Time{})
+    if err != nil {
+        c.conn.Close()
+        c.state = StateClosed
+        c.active = nil
+        return err
+    }
+    return nil
+}
+
+
+// PeekBody returns up to maxBytes of the body of the given message, as sent
+// (without decoding the transfer encoding), e.g. to sniff the content type or
+// build a preview. Only the start of the message is used; the rest is
+// abandoned with AbortRetr, so a large message can cost a reconnect instead
+// of a full download, see there.
+func (c *Client) PeekBody(msg, maxBytes int) (body []byte, err error) {
+    r, err := c.RetrReader(msg)
+    if err != nil {
+        return
+    }
+    defer func() {
+        if e := c.AbortRetr(); err == nil {
+            err = e
+        }
+    }()
+
+    br := bufio.NewReader(r)
+    if _, err = textproto.NewReader(br).ReadMIMEHeader(); err != nil {
+        if err == io.EOF {
+            err = nil
+        }
+        return
+    }
+
+    if maxBytes < 0 {
+        maxBytes = 0
+    }
+    body = make([]byte, maxBytes)
+    n, err := io.ReadFull(br, body)
+    if err == io.EOF || err == io.ErrUnexpectedEOF {
+        err = nil
+    }
+    return body[:n], err
+}
+
+
+// RetrTo streams the given message into w, as RetrReader would return it, and
+// returns the number of bytes written. Wrap w (e.g. in a gzip.Writer) to
+// transform the message on the fly.
+func (c *Client) RetrTo(msg int, w io.Writer) (n int64, err error) {
+    r, err := c.RetrReader(msg)
+    if err != nil {
+        return
+    }
+    n, err = io.Copy(w, r)
+    if e := r.Close(); err == nil {
+        err = e
+    }
+    return
+}
+
+
+// RetrToTempFile streams the given message into a new temporary file and
+// returns it positioned at the start, with the size of the message. This
+// gives a seekable copy of a large message without holding it in memory.
+// The file is removed from its directory at once, so that it disappears
+// when closed; on systems that cannot remove open files (Windows), the
+// caller has to remove f.Name() after closing it. If the retrieval fails,
+// the file is closed and removed.
+func (c *Client) RetrToTempFile(msg int) (f *os.File, n int64, err error) {
+    f, err = os.CreateTemp("", "pop3-retr-")
+    if err != nil {
+        return nil, 0, err
+    }
+    if name := f.Name(); os.Remove(name) != nil {
+        defer func() {
+            if err != nil {
+                os.Remove(name)
+            }
+        }()
+    }
+
+    if n, err = c.RetrTo(msg, f); err == nil {
+        _, err = f.Seek(0, io.SeekStart)
+    }
+    if err != nil {
+        f.Close()
+        return nil, 0, err
+    }
+    return f, n, nil
+}
+
+
+// VerifyResult is the outcome of VerifiedRetr.
+type VerifyResult struct {
+    BytesWritten    int64 // size of the message as written, without dot-stuffing
+    ListedSize      int64 // size reported by LIST
+    Lines           int64 // lines in the message
+    DotStuffedLines int64 // lines sent with an extra leading dot
+    Plausible       bool  // ListedSize is explained by BytesWritten, see VerifiedRetr
+}
+
+
+// VerifiedRetr streams the given message into w like RetrTo and checks its
+// size against the one LIST reports, e.g. to make sure an archived copy is
+// complete. Servers compute that size in different ways, so the result is
+// Plausible if ListedSize is exactly BytesWritten, adjusted for any of: the
+// server storing the message with LF instead of CRLF line endings (or the
+// other way around), and counting the dots added by dot-stuffing. A message
+// cut short or padded by even one byte is not plausible.
+//
+// A size mismatch is not an error; errors are only returned for a failed
+// command, transfer or write (and, with SetVerifySize on, for a size beyond
+// its tolerance).
+func (c *Client) VerifiedRetr(msg int, w io.Writer) (res VerifyResult, err error) {
+    size, err := c.LIST(msg)
+    if err != nil {
+        return
+    }
+    res.ListedSize = int64(size)
+
+    rc, err := c.RetrReader(msg)
+    if err != nil {
+        return
+    }
+    r := rc.(*dotReader)
+    res.BytesWritten, err = io.Copy(w, r)
+    if e := r.Close(); err == nil {
+        err = e
+    }
+    if err != nil {
+        return
+    }
+
+    res.Lines = r.lines
+    res.DotStuffedLines = r.stuffed
+    n := res.BytesWritten
+    for _, base := range []int64{n, n - r.crlf, n + r.lines - r.crlf} {
+        if res.ListedSize == base || res.ListedSize == base+r.stuffed {
+            res.Plausible = true
+        }
+    }
+    return
+}
+
+
+// RetrRange streams the messages from..to (inclusive) to fn, in order. The
+// reader is only valid during the call; whatever fn does not read is
+// discarded. If the server supports PIPELINING, the RETR commands are sent
+// in batches (see SetPipelineBatch), otherwise one at a time. A negative
+// response, e.g. for a deleted message, or an error returned by fn stops
+// the range: the responses already requested are read and discarded, so the
+// connection stays usable, and the error is returned.
+func (c *Client) RetrRange(from, to int, fn func(msg int, body io.Reader) error) (err error) {
+    if err = c.requireState(StateTransaction); err != nil {
+        return
+    }
+    if from > to {
+        return nil
+    }
+    if err = c.checkMsg(from); err != nil {
+        return
+    }
+    if err = c.checkMsg(to); err != nil {
+        return
+    }
+    msgs := make([]int, 0, to-from+1)
+    for m := from; m <= to; m++ {
+        msgs = append(msgs, m)
+    }
+    return c.retrEach(msgs, fn)
+}
+
+
+// BulkRetrSmall works like RetrRange for the given messages, but hands each
+// message to fn as a whole. It is meant for many small messages, where the
+// round trips of fetching them one by one take most of the time: on servers
+// with PIPELINING, the RETR commands go out in batches (see
+// SetPipelineBatch), so that a batch costs a single round trip. The messages
+// are held in memory one at a time, in a buffer reused for the next message:
+// body is only valid during the call to fn.
+func (c *Client) BulkRetrSmall(msgs []int, fn func(msg int, body []byte) error) error {
+    if err := c.requireState(StateTransaction); err != nil {
+        return err
+    }
+    for _, m := range msgs {
+        if err := c.checkMsg(m); err != nil {
+            return err
+        }
+    }
+    var buf bytes.Buffer
+    return c.retrEach(msgs, func(msg int, r io.Reader) error {
+        buf.Reset()
+        if _, err := buf.ReadFrom(r); err != nil {
+            return err
+        }
+        return fn(msg, buf.Bytes())
+    })
+}
+
+
+// retrEach does the work of RetrRange and BulkRetrSmall.
+func (c *Client) retrEach(msgs []int, fn func(msg int, body io.Reader) error) (err error) {
+    batch, err := c.batchSize()
+    if err != nil {
+        return
+    }
+    if err = c.warmup(); err != nil {
+        return
+    }
+    defer func() {
+        // after an error, the queued commands are not sent
+        if c.holdQueue {
+            c.holdQueue = false
+            c.queue = nil
+        }
+    }()
+
+    var fnErr error
+    for start := 0; start < len(msgs) && fnErr == nil; start += batch {
+        end := start + batch
+        if end > len(msgs) {
+            end = len(msgs)
+        }
+        cmds := make([]string, 0, end-start)
+        for _, m := range msgs[start:end] {
+            cmds = append(cmds, c.command("RETR %d\r\n", m))
+        }
+        if err = c.write(strings.Join(cmds, "")); err != nil {
+            return
+        }
+        c.holdQueue = true
+
+        for i, cmd := range cmds {
+            msg := msgs[start+i]
+            if _, e := c.response(cmd); e != nil {
+                if _, ok := e.(*Error); !ok {
+                    return e
+                }
+                if fnErr == nil {
+                    fnErr = fmt.Errorf("message %d: %w", msg, e)
+                }
+                continue
+            }
+
+            r := c.newDotReader()
+            c.active = r
+            if fnErr == nil {
+                fnErr = fn(msg, r)
+            }
+            if err = r.Close(); err != nil {
+                return
+            }
+        }
+
+        c.holdQueue = false
+        if e := c.runQueue(); e != nil && fnErr == nil {
+            fnErr = e
+        }
+    }
+    return fnErr
+}
+
+
+// EachOldestFirst streams every message to handler, from the lowest message
+// number (the oldest message) up. The MailItem passed along has MsgNum and
+// Size set. The reader is only valid during the call; whatever handler does
+// not read is discarded. The first error returned by handler stops the
+// iteration and is returned.
+func (c *Client) EachOldestFirst(handler func(MailItem, io.Reader) error) error {
+    msgs, sizes, err := c.ListAll()
+    if err != nil {
+        return err
+    }
+
+    for i, m := range msgs {
+        r, err := c.RetrReader(m)
+        if err != nil {
+            return err
+        }
+        err = handler(MailItem{MsgNum: m, Size: sizes[i]}, r)
+        if e := r.Close(); err == nil {
+            err = e
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
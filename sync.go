@@ -0,0 +1,129 @@
+package pop3
+
+import (
+    "context"
+    "io"
+)
+
+// UIDStore remembers the unique ids of the messages a Syncer has handled.
+// Implementations may keep them in a file, a database, etc.
+type UIDStore interface {
+    Seen(uid string) bool
+    Mark(uid string) error
+}
+
+
+// BloomFilter is a probabilistic set of unique ids, e.g. a bloom filter.
+// MightContain may report ids that were never added (false positives), but
+// must report every id that was.
+type BloomFilter interface {
+    MightContain(uid string) bool
+    Add(uid string)
+}
+
+
+// BloomStore returns a UIDStore backed by f, for maildrops with so many
+// handled messages that an exact set of their ids takes too much memory.
+// The price is the false positive rate of f: a new message whose id f
+// mistakes for a seen one is skipped by the Syncer, silently and for good.
+// Size the filter for the expected number of ids and an acceptable rate,
+// and use an exact UIDStore (e.g. backed by a map or a database) where no
+// message may be missed.
+func BloomStore(f BloomFilter) UIDStore {
+    return bloomStore{f}
+}
+
+
+type bloomStore struct {
+    f BloomFilter
+}
+
+func (s bloomStore) Seen(uid string) bool {
+    return s.f.MightContain(uid)
+}
+
+func (s bloomStore) Mark(uid string) error {
+    s.f.Add(uid)
+    return nil
+}
+
+
+// Syncer downloads the messages of a maildrop that have not been handled
+// before, identified by their UIDL unique ids. Messages are left on the
+// server.
+type Syncer struct {
+    dial  func() (*Client, error)
+    auth  func(*Client) error
+    store UIDStore
+}
+
+
+// NewSyncer returns a Syncer which connects with dial, logs in with auth and
+// keeps track of handled messages in store. Use ProviderAuth for auth to get
+// fresh credentials, e.g. OAuth tokens, for every run.
+func NewSyncer(dial func() (*Client, error), auth func(*Client) error, store UIDStore) *Syncer {
+    return &Syncer{dial: dial, auth: auth, store: store}
+}
+
+
+// Run opens a session and streams every message whose unique id is not in
+// the store to handler, oldest first. The MailItem passed along has MsgNum,
+// Size and UID set. A message is marked in the store only after handler
+// returned nil for it; the first error stops the run and is returned. Run
+// also stops, returning ctx.Err(), when ctx is done. If the server does not
+// support UIDL, Run fails with ErrUIDLUnsupported.
+func (s *Syncer) Run(ctx context.Context, handler func(MailItem, io.Reader) error) (err error) {
+    c, err := s.dial()
+    if err != nil {
+        return
+    }
+    defer func() {
+        if e := c.QUIT(); err == nil {
+            err = e
+        }
+    }()
+
+    if err = s.auth(c); err != nil {
+        return
+    }
+
+    msgs, uids, err := c.uidlAll()
+    if err != nil {
+        return
+    }
+    listed, sizes, err := c.ListAll()
+    if err != nil {
+        return
+    }
+    size := make(map[int]int, len(listed))
+    for i, m := range listed {
+        size[m] = sizes[i]
+    }
+
+    for i, m := range msgs {
+        if s.store.Seen(uids[i]) {
+            continue
+        }
+        if err = ctx.Err(); err != nil {
+            return
+        }
+
+        var r io.ReadCloser
+        r, err = c.RetrReader(m)
+        if err != nil {
+            return
+        }
+        err = handler(MailItem{MsgNum: m, Size: size[m], UID: uids[i]}, r)
+        if e := r.Close(); err == nil {
+            err = e
+        }
+        if err != nil {
+            return
+        }
+
+        if err = s.store.Mark(uids[i]); err != nil {
+            return
+        }
+    }
+    return
+}
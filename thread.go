@@ -0,0 +1,81 @@
+package pop3
+
+// Thread is a message in a conversation tree, with the replies to it.
+type Thread struct {
+    Item     MailItem
+    Children []*Thread
+}
+
+
+// ThreadMessages groups messages into conversations using their Message-ID,
+// In-Reply-To and References headers, and returns the root of each
+// conversation. A message whose parent is not among items (or that has no
+// parent) becomes a root. Roots and children keep the order of items.
+func ThreadMessages(items []MailItem) []Thread {
+    nodes := make([]*Thread, len(items))
+    byID := make(map[string]*Thread)
+    for i, item := range items {
+        nodes[i] = &Thread{Item: item}
+        if id := item.MessageID; id != "" {
+            if _, ok := byID[id]; !ok {
+                byID[id] = nodes[i]
+            }
+        }
+    }
+
+    parents := make(map[*Thread]*Thread)
+    roots := make([]Thread, 0)
+    for _, node := range nodes {
+        parent := findParent(node, byID, parents)
+        if parent == nil {
+            continue
+        }
+        parents[node] = parent
+    }
+
+    for _, node := range nodes {
+        if parent, ok := parents[node]; ok {
+            parent.Children = append(parent.Children, node)
+        }
+    }
+    for _, node := range nodes {
+        if _, ok := parents[node]; !ok {
+            roots = append(roots, *node)
+        }
+    }
+    return roots
+}
+
+
+// findParent returns the nearest ancestor of node present in byID, skipping
+// any candidate that would create a cycle.
+func findParent(node *Thread, byID map[string]*Thread, parents map[*Thread]*Thread) *Thread {
+    var candidates []string
+    candidates = append(candidates, node.Item.InReplyTo...)
+    for i := len(node.Item.References) - 1; i >= 0; i-- {
+        candidates = append(candidates, node.Item.References[i])
+    }
+
+    for _, id := range candidates {
+        parent, ok := byID[id]
+        if !ok || parent == node {
+            continue
+        }
+        if isAncestor(node, parent, parents) {
+            continue
+        }
+        return parent
+    }
+    return nil
+}
+
+
+// isAncestor reports whether a is an ancestor of (or equal to) b.
+func isAncestor(a, b *Thread, parents map[*Thread]*Thread) bool {
+    for n := b; n != nil; n = parents[n] {
+        if n == a {
+            return true
+        }
+    }
+    return false
+}
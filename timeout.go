@@ -0,0 +1,47 @@
+package pop3
+
+import (
+    "time"
+)
+
+// DefaultTimeout is how long a client waits for data from the server before
+// a read fails, unless changed with SetTimeout.
+const DefaultTimeout = 5 * time.Minute
+
+
+// SetTimeout sets how long the client waits for the server to send anything
+// before the pending command fails with a timeout error. The timer restarts
+// with every chunk of data received, so a large message that keeps arriving
+// is never cut off, while a server that stops responding no longer blocks
+// the caller forever. New clients use DefaultTimeout; d == 0 waits without
+// limit, as clients did before the default was introduced.
+//
+// After a timeout the session is out of step with the server: use Reconnect.
+// While a context passed to DialWithContext or Shutdown is in effect, it
+// bounds the reads instead.
+func (c *Client) SetTimeout(d time.Duration) {
+    if d <= 0 {
+        d = -1
+    }
+    c.timeout = d
+}
+
+
+// readTimeout returns the read timeout, 0 meaning none.
+func (c *Client) readTimeout() time.Duration {
+    switch {
+    case c.timeout == 0:
+        return DefaultTimeout
+    case c.timeout < 0:
+        return 0
+    }
+    return c.timeout
+}
+
+
+// setReadDeadline sets a read deadline which overrides the timeout until it
+// is cleared with the zero time.
+func (c *Client) setReadDeadline(t time.Time) {
+    c.conn.SetReadDeadline(t)
+    c.pinned = !t.IsZero()
+}
@@ -0,0 +1,201 @@
+package pop3
+
+import (
+    "errors"
+    "fmt"
+    "io"
+)
+
+// ErrNoSuchUID is returned when no message has the given unique id.
+var ErrNoSuchUID = errors.New("no message with this unique id")
+
+// ErrUIDLUnsupported is returned by methods based on unique ids when the
+// server does not implement the optional UIDL command.
+var ErrUIDLUnsupported = errors.New("server does not support UIDL")
+
+
+// uidlSupported reports whether the server supports UIDL, as far as is known:
+// from CAPA if the server implements it, otherwise from an earlier UIDL
+// failure. If nothing is known, it optimistically returns true.
+func (c *Client) uidlSupported() (bool, error) {
+    if c.noUIDL {
+        return false, nil
+    }
+    caps, err := c.capabilities()
+    if err != nil {
+        return false, err
+    }
+    if len(caps.Raw) > 0 {
+        return caps.UIDL, nil
+    }
+    return true, nil
+}
+
+
+// uidlAll works like UidlAll, but returns ErrUIDLUnsupported if the server
+// lacks UIDL. All features based on unique ids go through it.
+func (c *Client) uidlAll() (msgs []int, uids []string, err error) {
+    ok, err := c.uidlSupported()
+    if err != nil {
+        return
+    }
+    if !ok {
+        return nil, nil, ErrUIDLUnsupported
+    }
+
+    msgs, uids, err = c.UidlAll()
+    if _, ok := err.(*Error); ok && !retryable(err) {
+        c.noUIDL = true
+        return nil, nil, ErrUIDLUnsupported
+    }
+    return
+}
+
+
+// loadUIDs fills the cache mapping unique ids to message numbers with a
+// single UIDL command. Message numbers are only valid for one session, so
+// the cache is dropped when a new session starts.
+func (c *Client) loadUIDs() error {
+    msgs, uids, err := c.uidlAll()
+    if err != nil {
+        return err
+    }
+    c.uids = make(map[string]int, len(uids))
+    for i, uid := range uids {
+        c.uids[uid] = msgs[i]
+    }
+    return nil
+}
+
+
+// msgByUID returns the message number of the message with the given unique
+// id, issuing UIDL only if the cache is empty.
+func (c *Client) msgByUID(uid string) (int, error) {
+    if c.uids == nil {
+        if err := c.loadUIDs(); err != nil {
+            return 0, err
+        }
+    }
+    msg, ok := c.uids[uid]
+    if !ok {
+        return 0, ErrNoSuchUID
+    }
+    return msg, nil
+}
+
+
+// RetrByUID works like RETR, for the message with the given unique id. The
+// ids are fetched with UIDL once per session (or taken from GetListWithOptions
+// with PopulateUIDCache).
+func (c *Client) RetrByUID(uid string) (text string, err error) {
+    msg, err := c.msgByUID(uid)
+    if err != nil {
+        return
+    }
+    return c.RETR(msg)
+}
+
+
+// DeleByUID works like DELE, for the message with the given unique id. In
+// read-only mode, it returns ErrReadOnly without sending UIDL.
+func (c *Client) DeleByUID(uid string) error {
+    if c.readOnly {
+        return ErrReadOnly
+    }
+    msg, err := c.msgByUID(uid)
+    if err != nil {
+        return err
+    }
+    if err = c.DELE(msg); err != nil {
+        return err
+    }
+    delete(c.uids, uid)
+    return nil
+}
+
+
+// ErrNotRestartable is returned by RetrResilient when a download broke off
+// after data was written to a writer that cannot be rewound.
+var ErrNotRestartable = errors.New("download interrupted, writer cannot be rewound")
+
+
+// RetrResilient streams the message with the given unique id into w like
+// RetrTo, surviving broken connections: on a transport error, it reconnects,
+// authenticates again (see Reconnect) and restarts the download from the
+// beginning, since POP3 cannot resume one. It gives up after maxRetries
+// restarts. The unique id finds the message again in the new session.
+//
+// To restart, w is rewound to where it was at the start: w must implement
+// io.Seeker and Truncate(int64) error, as *os.File does. Otherwise a failure
+// after data was written returns ErrNotRestartable. Deletions pending in a
+// broken session are lost.
+func (c *Client) RetrResilient(uid string, w io.Writer, maxRetries int) (n int64, err error) {
+    type truncater interface {
+        io.Seeker
+        Truncate(size int64) error
+    }
+    start := int64(-1)
+    t, rewindable := w.(truncater)
+    if rewindable {
+        if start, err = t.Seek(0, io.SeekCurrent); err != nil {
+            return
+        }
+    }
+
+    for attempt := 0; ; attempt++ {
+        var m int
+        if m, err = c.msgByUID(uid); err == nil {
+            n, err = c.RetrTo(m, w)
+        }
+        if err == nil || !isTransportError(err) || attempt >= maxRetries {
+            return
+        }
+
+        if n > 0 {
+            if !rewindable {
+                return n, fmt.Errorf("%w: %v", ErrNotRestartable, err)
+            }
+            if err = t.Truncate(start); err != nil {
+                return
+            }
+            if _, err = t.Seek(start, io.SeekStart); err != nil {
+                return
+            }
+            n = 0
+        }
+
+        // a failed reconnect counts as an attempt as well
+        for err = c.Reconnect(); err != nil && isTransportError(err) && attempt < maxRetries; err = c.Reconnect() {
+            attempt++
+        }
+        if err != nil {
+            return
+        }
+    }
+}
+
+
+// DiffUIDs compares two UIDL snapshots, e.g. the uids returned by UidlAll in
+// an earlier and the current session. added holds the uids only in new
+// (mail that arrived since), removed those only in old (mail deleted on the
+// server). Both keep the order of their snapshot and list each uid once.
+func DiffUIDs(old, new []string) (added, removed []string) {
+    return uidsMissing(new, old), uidsMissing(old, new)
+}
+
+
+// uidsMissing returns the uids of a which are not in b.
+func uidsMissing(a, b []string) []string {
+    seen := make(map[string]bool, len(a)+len(b))
+    for _, uid := range b {
+        seen[uid] = true
+    }
+    var missing []string
+    for _, uid := range a {
+        if !seen[uid] {
+            seen[uid] = true
+            missing = append(missing, uid)
+        }
+    }
+    return missing
+}